@@ -0,0 +1,128 @@
+// Package clock provides the server's notion of the current time, which can be shifted
+// or replaced to test daily and weekly rollovers.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a source of the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// System is a Clock backed by the host clock.
+type System struct{}
+
+func (System) Now() time.Time {
+	return time.Now()
+}
+
+// Fake is a Clock that only moves when told to.
+type Fake struct {
+	sync.Mutex
+	now time.Time
+}
+
+// NewFake returns a Fake stopped at t.
+func NewFake(t time.Time) *Fake {
+	return &Fake{now: t}
+}
+
+func (f *Fake) Now() time.Time {
+	f.Lock()
+	defer f.Unlock()
+	return f.now
+}
+
+// Set stops the Fake at t.
+func (f *Fake) Set(t time.Time) {
+	f.Lock()
+	f.now = t
+	f.Unlock()
+}
+
+// Advance moves the Fake forward by d.
+func (f *Fake) Advance(d time.Duration) {
+	f.Lock()
+	f.now = f.now.Add(d)
+	f.Unlock()
+}
+
+// Adjustable wraps a Clock with an offset and a time zone.
+type Adjustable struct {
+	sync.RWMutex
+	base     Clock
+	offset   time.Duration
+	location *time.Location
+}
+
+// NewAdjustable returns an Adjustable reporting the time of base in loc.
+func NewAdjustable(base Clock, loc *time.Location) *Adjustable {
+	return &Adjustable{base: base, location: loc}
+}
+
+// Now returns the base time shifted by the offset, in the clock's time zone.
+func (a *Adjustable) Now() time.Time {
+	a.RLock()
+	defer a.RUnlock()
+	return a.base.Now().Add(a.offset).In(a.location)
+}
+
+// Location returns the clock's time zone.
+func (a *Adjustable) Location() *time.Location {
+	a.RLock()
+	defer a.RUnlock()
+	return a.location
+}
+
+// SetLocation changes the clock's time zone.
+func (a *Adjustable) SetLocation(loc *time.Location) {
+	a.Lock()
+	a.location = loc
+	a.Unlock()
+}
+
+// SetBase replaces the underlying Clock, e.g. with a Fake.
+func (a *Adjustable) SetBase(base Clock) {
+	a.Lock()
+	a.base = base
+	a.Unlock()
+}
+
+// Offset returns how far the clock is shifted from its base.
+func (a *Adjustable) Offset() time.Duration {
+	a.RLock()
+	defer a.RUnlock()
+	return a.offset
+}
+
+// SetOffset shifts the clock by d from its base.
+func (a *Adjustable) SetOffset(d time.Duration) {
+	a.Lock()
+	a.offset = d
+	a.Unlock()
+}
+
+// Travel moves the clock forward by d, or backward if d is negative.
+func (a *Adjustable) Travel(d time.Duration) {
+	a.Lock()
+	a.offset += d
+	a.Unlock()
+}
+
+// Midnight returns the start of the day of t in its time zone.
+func Midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// WeekStart returns midnight of the Monday starting the week of t.
+func WeekStart(t time.Time) time.Time {
+	midnight := Midnight(t)
+	offset := int(midnight.Weekday()) - int(time.Monday)
+	if offset < 0 {
+		offset += 7
+	}
+	return midnight.AddDate(0, 0, -offset)
+}
@@ -0,0 +1,46 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var jst = time.FixedZone("UTC+9", 9*60*60)
+
+func TestAdjustable(t *testing.T) {
+	fake := NewFake(time.Date(2024, 1, 1, 14, 59, 0, 0, time.UTC))
+	c := NewAdjustable(fake, jst)
+	if got := c.Now(); got.Day() != 1 || got.Hour() != 23 {
+		t.Errorf("Now() = %s, want 2024-01-01 23:59 JST", got)
+	}
+	fake.Advance(time.Minute)
+	if got := Midnight(c.Now()); !got.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, jst)) {
+		t.Errorf("Midnight() after rollover = %s", got)
+	}
+	c.Travel(48 * time.Hour)
+	if got := c.Now(); got.Day() != 4 {
+		t.Errorf("Now() after travel = %s, want 2024-01-04", got)
+	}
+	c.SetOffset(0)
+	if c.Offset() != 0 || c.Now().Day() != 2 {
+		t.Errorf("Now() after reset = %s, want 2024-01-02", c.Now())
+	}
+}
+
+func TestWeekStart(t *testing.T) {
+	tests := []struct {
+		day  int
+		want int
+	}{
+		{1, 1}, // Monday
+		{3, 1},
+		{7, 1}, // Sunday
+		{8, 8},
+	}
+	for _, tt := range tests {
+		got := WeekStart(time.Date(2024, 1, tt.day, 12, 0, 0, 0, jst))
+		if got.Day() != tt.want || got.Hour() != 0 {
+			t.Errorf("WeekStart(2024-01-%02d) = %s, want 2024-01-%02d", tt.day, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package jpk
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// The Huffman table is a tree of int16 nodes: values below 0x100 are bytes and values from 0x100 are
+// branches whose two children are stored at entries (node-0x100)*2 and (node-0x100)*2+1.
+// It is preceded by the root node, which is also the highest branch, and followed by the coded bits.
+
+// huffmanReader decodes bytes from a Huffman coded stream.
+type huffmanReader struct {
+	root  int
+	table []byte
+	data  []byte
+	pos   int
+	flag  byte
+	shift int
+}
+
+func newHuffmanReader(body []byte) (*huffmanReader, error) {
+	if len(body) < 2 {
+		return nil, fmt.Errorf("%w: missing Huffman table", ErrCorrupt)
+	}
+	root := int(int16(binary.LittleEndian.Uint16(body)))
+	tableSize := (root - 0xFF) * 4
+	if root < 0x100 || 2+tableSize > len(body) {
+		return nil, fmt.Errorf("%w: bad Huffman table", ErrCorrupt)
+	}
+	return &huffmanReader{
+		root:  root,
+		table: body[2 : 2+tableSize],
+		data:  body[2+tableSize:],
+	}, nil
+}
+
+func (r *huffmanReader) ReadByte() (byte, error) {
+	node := r.root
+	for node >= 0x100 {
+		r.shift--
+		if r.shift < 0 {
+			if r.pos >= len(r.data) {
+				if node == r.root {
+					return 0, io.EOF
+				}
+				return 0, io.ErrUnexpectedEOF
+			}
+			r.shift = 7
+			r.flag = r.data[r.pos]
+			r.pos++
+		}
+		i := ((node-0x100)*2 + int(r.flag>>r.shift&1)) * 2
+		if i+2 > len(r.table) {
+			return 0, fmt.Errorf("%w: bad Huffman node", ErrCorrupt)
+		}
+		node = int(int16(binary.LittleEndian.Uint16(r.table[i:])))
+		if node < 0 {
+			return 0, fmt.Errorf("%w: bad Huffman node", ErrCorrupt)
+		}
+	}
+	return byte(node), nil
+}
+
+type huffmanNode struct {
+	weight int
+	value  int // Byte for leaves, branch number for branches
+	order  int // Breaks weight ties so the tree is deterministic
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].weight != h[j].weight {
+		return h[i].weight < h[j].weight
+	}
+	return h[i].order < h[j].order
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+// encodeHuffman appends the Huffman table and coded bits of data to out.
+func encodeHuffman(out []byte, data []byte) []byte {
+	var weights [0x100]int
+	for _, c := range data {
+		weights[c]++
+	}
+	h := &huffmanHeap{}
+	for c, w := range weights {
+		if w > 0 {
+			*h = append(*h, huffmanNode{weight: w, value: c, order: c})
+		}
+	}
+	// The tree needs at least one branch
+	for c := 0; h.Len() < 2; c++ {
+		if weights[c] == 0 {
+			*h = append(*h, huffmanNode{value: c, order: c})
+		}
+	}
+	heap.Init(h)
+
+	var table []int16
+	for order := 0x100; h.Len() > 1; order++ {
+		a := heap.Pop(h).(huffmanNode)
+		b := heap.Pop(h).(huffmanNode)
+		table = append(table, int16(a.value), int16(b.value))
+		heap.Push(h, huffmanNode{weight: a.weight + b.weight, value: 0x100 + len(table)/2 - 1, order: order})
+	}
+	root := 0x100 + len(table)/2 - 1
+
+	// Walk the tree to find the bits leading to each byte
+	var codes [0x100][]byte
+	var walk func(node int, code []byte)
+	walk = func(node int, code []byte) {
+		if node < 0x100 {
+			codes[node] = code
+			return
+		}
+		for b := 0; b < 2; b++ {
+			walk(int(table[(node-0x100)*2+b]), append(code[:len(code):len(code)], byte(b)))
+		}
+	}
+	walk(root, nil)
+
+	out = binary.LittleEndian.AppendUint16(out, uint16(root))
+	for _, v := range table {
+		out = binary.LittleEndian.AppendUint16(out, uint16(v))
+	}
+	var flag byte
+	shift := 8
+	for _, c := range data {
+		for _, b := range codes[c] {
+			shift--
+			flag |= b << shift
+			if shift == 0 {
+				out = append(out, flag)
+				flag, shift = 0, 8
+			}
+		}
+	}
+	if shift < 8 {
+		out = append(out, flag)
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+// Package jpk implements the JPK (JKR) compression format used by Monster Hunter Frontier files.
+//
+// This is HEAVILY based on
+// https://github.com/Chakratos/ReFrontier/blob/master/ReFrontier/Unpack.cs
+// and Pack.cs. Decoding keeps no shared state, so it is safe to use from multiple goroutines.
+package jpk
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Type is the compression applied to the data of a JPK file.
+type Type uint16
+
+const (
+	TypeRW    Type = 0 // Stored without compression
+	TypeHFIRW Type = 2 // Huffman coded
+	TypeLZ    Type = 3 // LZ77 compressed
+	TypeHFI   Type = 4 // LZ77 compressed, then Huffman coded
+)
+
+const (
+	magic      = 0x1A524B4A // "JKR\x1A"
+	version    = 0x108
+	headerSize = 16
+	maxSize    = 1 << 26 // Largest decoded size accepted from a header
+)
+
+var (
+	// ErrNotJPK is returned when decoding data without a JPK header.
+	ErrNotJPK = errors.New("jpk: not a JPK file")
+	// ErrCorrupt is returned when the compressed data is malformed or truncated.
+	ErrCorrupt = errors.New("jpk: corrupt data")
+	// ErrUnsupportedType is returned for a compression type this package does not know.
+	ErrUnsupportedType = errors.New("jpk: unsupported type")
+)
+
+// IsJPK reports whether data starts with a JPK header.
+func IsJPK(data []byte) bool {
+	return len(data) >= headerSize && binary.LittleEndian.Uint32(data) == magic
+}
+
+// Decode decompresses a JPK file of any type.
+func Decode(data []byte) ([]byte, error) {
+	if !IsJPK(data) {
+		return nil, ErrNotJPK
+	}
+	t := Type(binary.LittleEndian.Uint16(data[6:]))
+	start := binary.LittleEndian.Uint32(data[8:])
+	size := binary.LittleEndian.Uint32(data[12:])
+	if start < headerSize || start > uint32(len(data)) || size > maxSize {
+		return nil, fmt.Errorf("%w: bad header", ErrCorrupt)
+	}
+	out := make([]byte, size)
+	body := data[start:]
+
+	var err error
+	switch t {
+	case TypeRW:
+		err = readRaw(bytes.NewReader(body), out)
+	case TypeLZ:
+		err = decodeLZ(bytes.NewReader(body), out)
+	case TypeHFIRW, TypeHFI:
+		var r *huffmanReader
+		r, err = newHuffmanReader(body)
+		if err != nil {
+			break
+		}
+		if t == TypeHFIRW {
+			err = readRaw(r, out)
+		} else {
+			err = decodeLZ(r, out)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedType, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Unpack decompresses data if it is a valid JPK file, otherwise it is returned unchanged.
+func Unpack(data []byte) []byte {
+	out, err := Decode(data)
+	if err != nil {
+		return data
+	}
+	return out
+}
+
+// Encode compresses data into a JPK file of the given type.
+func Encode(t Type, data []byte) ([]byte, error) {
+	if len(data) > maxSize {
+		return nil, fmt.Errorf("jpk: %d bytes exceeds the maximum size", len(data))
+	}
+	out := make([]byte, headerSize, headerSize+len(data)/2)
+	binary.LittleEndian.PutUint32(out, magic)
+	binary.LittleEndian.PutUint16(out[4:], version)
+	binary.LittleEndian.PutUint16(out[6:], uint16(t))
+	binary.LittleEndian.PutUint32(out[8:], headerSize)
+	binary.LittleEndian.PutUint32(out[12:], uint32(len(data)))
+
+	switch t {
+	case TypeRW:
+		out = append(out, data...)
+	case TypeLZ:
+		out = encodeLZ(out, data)
+	case TypeHFIRW:
+		out = encodeHuffman(out, data)
+	case TypeHFI:
+		out = encodeHuffman(out, encodeLZ(nil, data))
+	default:
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedType, t)
+	}
+	return out, nil
+}
+
+func readRaw(r io.ByteReader, out []byte) error {
+	var err error
+	for i := range out {
+		out[i], err = r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+	}
+	return nil
+}
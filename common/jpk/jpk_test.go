@@ -0,0 +1,121 @@
+package jpk
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+)
+
+var types = []Type{TypeRW, TypeHFIRW, TypeLZ, TypeHFI}
+
+func testInputs() map[string][]byte {
+	rng := rand.New(rand.NewSource(1))
+	random := make([]byte, 5000)
+	rng.Read(random)
+	// Repetitive data with matches near and far, including runs longer than a single copy
+	mixed := make([]byte, 40000)
+	for i := range mixed {
+		switch {
+		case i < 600:
+			mixed[i] = 0xAA
+		case rng.Intn(4) == 0:
+			mixed[i] = byte(rng.Intn(256))
+		default:
+			mixed[i] = mixed[i-1-rng.Intn(i)%9000]
+		}
+	}
+	return map[string][]byte{
+		"empty":  {},
+		"single": {0x42},
+		"text":   bytes.Repeat([]byte("Monster Hunter Frontier "), 50),
+		"random": random,
+		"mixed":  mixed,
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for name, data := range testInputs() {
+		for _, typ := range types {
+			enc, err := Encode(typ, data)
+			if err != nil {
+				t.Fatalf("%s type %d: Encode() error = %v", name, typ, err)
+			}
+			dec, err := Decode(enc)
+			if err != nil {
+				t.Fatalf("%s type %d: Decode() error = %v", name, typ, err)
+			}
+			if !bytes.Equal(dec, data) {
+				t.Errorf("%s type %d: round trip mismatch", name, typ)
+			}
+		}
+	}
+}
+
+func TestDecodeLZ(t *testing.T) {
+	// Three literals, a 33 byte copy, then three more literals
+	enc := []byte{0x4A, 0x4B, 0x52, 0x1A, 0x08, 0x01, 0x03, 0x00, 0x10, 0x00, 0x00, 0x00, 0x27, 0x00, 0x00, 0x00,
+		0x1C, 0x61, 0x62, 0x63, 0x00, 0x02, 0x07, 0x78, 0x79, 0x00, 0x7A}
+	want := append(bytes.Repeat([]byte("abc"), 12), "xyz"...)
+	got, err := Decode(enc)
+	if err != nil || !bytes.Equal(got, want) {
+		t.Errorf("Decode() = %q, %v, want %q", got, err, want)
+	}
+	if again, _ := Encode(TypeLZ, want); !bytes.Equal(again, enc) {
+		t.Errorf("Encode() = %#v, want %#v", again, enc)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	if _, err := Decode([]byte("not a jpk file at all")); !errors.Is(err, ErrNotJPK) {
+		t.Errorf("Decode(plain) error = %v, want ErrNotJPK", err)
+	}
+	data := testInputs()["text"]
+	for _, typ := range []Type{TypeRW, TypeHFIRW, TypeHFI} {
+		enc, _ := Encode(typ, data)
+		if _, err := Decode(enc[:len(enc)-len(enc)/4]); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("Decode(truncated type %d) error = %v, want ErrCorrupt", typ, err)
+		}
+	}
+	enc, _ := Encode(TypeLZ, data)
+	enc[6] = 9
+	if _, err := Decode(enc); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Decode(type 9) error = %v, want ErrUnsupportedType", err)
+	}
+	// A copy reaching before the start of the output
+	bad := []byte{0x4A, 0x4B, 0x52, 0x1A, 0x08, 0x01, 0x03, 0x00, 0x10, 0x00, 0x00, 0x00, 0x04, 0x00, 0x00, 0x00, 0x80, 0x05}
+	if _, err := Decode(bad); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Decode(bad copy) error = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	plain := []byte("plain quest data")
+	if got := Unpack(plain); !bytes.Equal(got, plain) {
+		t.Errorf("Unpack(plain) = %q, want it unchanged", got)
+	}
+	enc, _ := Encode(TypeHFI, plain)
+	if got := Unpack(enc); !bytes.Equal(got, plain) {
+		t.Errorf("Unpack(HFI) = %q, want %q", got, plain)
+	}
+}
+
+func TestConcurrentDecode(t *testing.T) {
+	data := testInputs()["mixed"]
+	enc, _ := Encode(TypeHFI, data)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				if dec, err := Decode(enc); err != nil || !bytes.Equal(dec, data) {
+					t.Error("concurrent Decode() mismatch")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,262 @@
+package jpk
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	minMatch    = 3
+	maxMatch    = 0x118  // Longest copy the one byte length form can describe
+	maxDistance = 0x2000 // Furthest back a copy can reach with a 13-bit offset
+	maxChain    = 256    // Candidates compared per position when searching for a match
+	hashBits    = 15
+)
+
+// lzDecoder holds the state of a single LZ decode.
+type lzDecoder struct {
+	r     io.ByteReader
+	flag  byte
+	shift int
+	out   []byte
+	n     int
+}
+
+func (d *lzDecoder) bit() (int, error) {
+	d.shift--
+	if d.shift < 0 {
+		d.shift = 7
+		var err error
+		d.flag, err = d.r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return int(d.flag>>d.shift) & 1, nil
+}
+
+func (d *lzDecoder) bits(count int) (int, error) {
+	v := 0
+	for i := 0; i < count; i++ {
+		b, err := d.bit()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<1 | b
+	}
+	return v, nil
+}
+
+func (d *lzDecoder) copy(offset int, length int) error {
+	src := d.n - offset - 1
+	if src < 0 || d.n+length > len(d.out) {
+		return fmt.Errorf("%w: copy out of range", ErrCorrupt)
+	}
+	// Copied a byte at a time, as the source may overlap what is being written
+	for i := 0; i < length; i++ {
+		d.out[d.n] = d.out[src+i]
+		d.n++
+	}
+	return nil
+}
+
+// decodeLZ fills out from the LZ stream in r.
+// A stream that ends between tokens leaves the rest of out zeroed, matching how the client handles it.
+func decodeLZ(r io.ByteReader, out []byte) error {
+	d := &lzDecoder{r: r, out: out}
+	for d.n < len(out) {
+		b, err := d.bit()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = d.token(b); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return fmt.Errorf("%w: %v", ErrCorrupt, io.ErrUnexpectedEOF)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *lzDecoder) token(b int) error {
+	if b == 0 {
+		if d.n >= len(d.out) {
+			return fmt.Errorf("%w: literal out of range", ErrCorrupt)
+		}
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		d.out[d.n] = c
+		d.n++
+		return nil
+	}
+	b, err := d.bit()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		length, err := d.bits(2)
+		if err != nil {
+			return err
+		}
+		offset, err := d.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		return d.copy(int(offset), length+3)
+	}
+	hi, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	lo, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	length := int(hi&0xE0) >> 5
+	offset := int(hi&0x1F)<<8 | int(lo)
+	if length != 0 {
+		return d.copy(offset, length+2)
+	}
+	b, err = d.bit()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		length, err = d.bits(4)
+		if err != nil {
+			return err
+		}
+		return d.copy(offset, length+2+8)
+	}
+	temp, err := d.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if temp != 0xFF {
+		return d.copy(offset, int(temp)+0x1A)
+	}
+	// Run of literal bytes
+	if d.n+offset+0x1B > len(d.out) {
+		return fmt.Errorf("%w: literal run out of range", ErrCorrupt)
+	}
+	for i := 0; i < offset+0x1B; i++ {
+		if d.out[d.n], err = d.r.ReadByte(); err != nil {
+			return err
+		}
+		d.n++
+	}
+	return nil
+}
+
+// lzEncoder appends an LZ stream to out.
+// Each flag byte is placed in the stream at the point the decoder first needs one of its bits.
+type lzEncoder struct {
+	out     []byte
+	flagPos int
+	shift   int
+}
+
+func (e *lzEncoder) bit(b int) {
+	if e.shift == 0 {
+		e.flagPos = len(e.out)
+		e.out = append(e.out, 0)
+		e.shift = 8
+	}
+	e.shift--
+	e.out[e.flagPos] |= byte(b&1) << e.shift
+}
+
+func (e *lzEncoder) bits(v int, count int) {
+	for i := count - 1; i >= 0; i-- {
+		e.bit(v >> i)
+	}
+}
+
+func (e *lzEncoder) match(length int, distance int) {
+	offset := distance - 1
+	e.bit(1)
+	switch {
+	case length <= 6 && offset <= 0xFF:
+		e.bit(0)
+		e.bits(length-3, 2)
+		e.out = append(e.out, byte(offset))
+	case length <= 9:
+		e.bit(1)
+		e.out = append(e.out, byte((length-2)<<5)|byte(offset>>8), byte(offset))
+	case length <= 25:
+		e.bit(1)
+		e.out = append(e.out, byte(offset>>8), byte(offset))
+		e.bit(0)
+		e.bits(length-10, 4)
+	default:
+		e.bit(1)
+		e.out = append(e.out, byte(offset>>8), byte(offset))
+		e.bit(1)
+		e.out = append(e.out, byte(length-0x1A))
+	}
+}
+
+func lzHash(data []byte) uint32 {
+	return (uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])) * 2654435761 >> (32 - hashBits)
+}
+
+// encodeLZ appends the LZ stream of data to out, using hash chains to find earlier copies.
+func encodeLZ(out []byte, data []byte) []byte {
+	e := &lzEncoder{out: out}
+	head := make([]int32, 1<<hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(data))
+	insert := func(i int) {
+		if i+minMatch <= len(data) {
+			h := lzHash(data[i:])
+			prev[i] = head[h]
+			head[h] = int32(i)
+		}
+	}
+
+	for i := 0; i < len(data); {
+		bestLength, bestDistance := 0, 0
+		if i+minMatch <= len(data) {
+			limit := len(data) - i
+			if limit > maxMatch {
+				limit = maxMatch
+			}
+			j := head[lzHash(data[i:])]
+			for chain := 0; j >= 0 && i-int(j) <= maxDistance && chain < maxChain; chain++ {
+				length := 0
+				for length < limit && data[int(j)+length] == data[i+length] {
+					length++
+				}
+				if length > bestLength {
+					bestLength, bestDistance = length, i-int(j)
+					if length == limit {
+						break
+					}
+				}
+				j = prev[j]
+			}
+		}
+		if bestLength < minMatch {
+			e.bit(0)
+			e.out = append(e.out, data[i])
+			insert(i)
+			i++
+			continue
+		}
+		e.match(bestLength, bestDistance)
+		for k := 0; k < bestLength; k++ {
+			insert(i + k)
+		}
+		i += bestLength
+	}
+	return e.out
+}
@@ -0,0 +1,62 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 Appendix B, truncated to six digits.
+var tests = []struct {
+	time int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+}
+
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, tt := range tests {
+		code, err := Code(secret, time.Unix(tt.time, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tt.code {
+			t.Errorf("Code(%d) = %s, want %s", tt.time, code, tt.code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, _ := Code(secret, now)
+	if !Validate(secret, code, now.Add(Period*time.Second)) {
+		t.Error("code from previous period was rejected")
+	}
+	if Validate(secret, code, now.Add(3*Period*time.Second)) {
+		t.Error("expired code was accepted")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, now)
+	if step, ok := Match(secret, code, now.Add(Period*time.Second)); !ok || step != uint64(now.Unix()/Period) {
+		t.Errorf("Match() = %d, %t, want %d, true", step, ok, now.Unix()/Period)
+	}
+	if _, ok := Match(secret, "12345", now); ok {
+		t.Error("short code was accepted")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"strings"
+	"testing"
 	"time"
 
 	"github.com/spf13/viper"
@@ -62,32 +63,54 @@ var versionStrings = []string{"S1.0", "S1.5", "S2.0", "S2.5", "S3.0", "S3.5", "S
 	"G5.1", "G5.2", "G6", "G6.1", "G7", "G8", "G8.1", "G9", "G9.1", "G10", "G10.1", "Z1", "Z2", "ZZ"}
 
 func (m Mode) String() string {
-	return versionStrings[m]
+	return versionStrings[m-1]
+}
+
+// ParseMode returns the Mode of a client version string such as "G10" or "ZZ".
+func ParseMode(s string) (Mode, bool) {
+	for i := range versionStrings {
+		if strings.ToUpper(s) == versionStrings[i] {
+			return Mode(i + 1), true
+		}
+	}
+	return 0, false
 }
 
 // Config holds the global server-wide config.
 type Config struct {
-	Host                   string `mapstructure:"Host"`
-	BinPath                string `mapstructure:"BinPath"`
-	Language               string
-	DisableSoftCrash       bool     // Disables the 'Press Return to exit' dialog allowing scripts to reboot the server automatically
-	HideLoginNotice        bool     // Hide the Erupe notice on login
-	LoginNotices           []string // MHFML string of the login notices displayed
-	PatchServerManifest    string   // Manifest patch server override
-	PatchServerFile        string   // File patch server override
-	DeleteOnSaveCorruption bool     // Attempts to save corrupted data will flag the save for deletion
-	ClientMode             string
-	RealClientMode         Mode
-	QuestCacheExpiry       int    // Number of seconds to keep quest data cached
-	CommandPrefix          string // The prefix for commands
-	AutoCreateAccount      bool   // Automatically create accounts if they don't exist
-	LoopDelay              int    // Delay in milliseconds between each loop iteration
-	DefaultCourses         []uint16
-	EarthStatus            int32
-	EarthID                int32
-	EarthMonsters          []int32
-	SaveDumps              SaveDumpOptions
-	Screenshots            ScreenshotsOptions
+	Host                string `mapstructure:"Host"`
+	BinPath             string `mapstructure:"BinPath"`
+	Language            string
+	DisableSoftCrash    bool     // Disables the 'Press Return to exit' dialog allowing scripts to reboot the server automatically
+	ShutdownDeadline    int      // Seconds to wait for sessions to be saved and logged out on shutdown
+	HideLoginNotice     bool     // Hide the Erupe notice on login
+	LoginNotices        []string // MHFML string of the login notices displayed
+	PatchServerManifest string   // Manifest patch server override
+	PatchServerFile     string   // File patch server override
+	ClientMode          string
+	RealClientMode      Mode
+	QuestCacheExpiry    int    // Number of seconds to keep quest data cached
+	FallbackQuest       string // Quest file sent in place of missing quest files, leave empty to fail the request
+	FallbackScenario    string // Scenario file sent in place of missing scenario files, leave empty to fail the request
+	ScanQuestFiles      bool   // Report event quests and scenarios missing from the BinPath on startup
+	QuestPack           string // Quest pack archive in the BinPath to load quests and scenarios from, leave empty to use loose files
+	WatchBinPath        bool   // Reload quest files as soon as they change in the BinPath
+	CompressQuests      bool   // JPK compress uncompressed quest and scenario files before sending them
+	CommandPrefix       string // The prefix for commands
+	AutoCreateAccount   bool   // Automatically create accounts if they don't exist
+	LoopDelay           int    // Delay in milliseconds between each loop iteration
+	TimeZone            int    // Offset of the server time zone from UTC in hours
+	TimeOffset          int    // Seconds to shift the server clock by, for testing events
+	DefaultCourses      []uint16
+	EarthStatus         int32   // Conquest War status used when the earth_schedule table is empty
+	EarthID             int32   // Conquest War ID used when the earth_schedule table is empty
+	EarthMonsters       []int32 // Conquest War monsters used when the earth_schedule table is empty
+	SaveDumps           SaveDumpOptions
+	SaveHistory         SaveHistoryOptions
+	SaveLayouts         []SaveLayout // Length of a decompressed save in each client mode's layout
+	Screenshots         ScreenshotsOptions
+	LoginLockout        LoginLockoutOptions
+	Mail                MailOptions
 
 	DebugOptions    DebugOptions
 	GameplayOptions GameplayOptions
@@ -107,6 +130,18 @@ type SaveDumpOptions struct {
 	OutputDir  string
 }
 
+type SaveHistoryOptions struct {
+	Enabled  bool
+	Length   int // Number of previous saves kept per character
+	Interval int // Minimum seconds between saves kept in history
+}
+
+// SaveLayout is the fixed length of the decompressed saves of a client mode.
+type SaveLayout struct {
+	Mode   string // Client mode of the layout
+	Length int    // Length in bytes of every decompressed save in this layout
+}
+
 type ScreenshotsOptions struct {
 	Enabled       bool
 	Host          string // Destination for screenshots uploaded to BBS
@@ -115,6 +150,24 @@ type ScreenshotsOptions struct {
 	UploadQuality int //Determines the upload quality to the server
 }
 
+// LoginLockoutOptions holds the failed login throttling config.
+type LoginLockoutOptions struct {
+	Enabled       bool
+	MaxAttempts   int // Failed attempts allowed per account before it is locked
+	MaxIPAttempts int // Failed attempts allowed per IP address before it is locked
+	BaseDelay     int // Seconds a lock lasts once the limit is reached, doubled for every further failure
+	MaxDelay      int // Maximum seconds a lock can last
+	Window        int // Seconds that failed attempts are remembered for
+}
+
+// MailOptions holds the player mail limits.
+type MailOptions struct {
+	SendLimit   int // Mail a character can send within the SendWindow, 0 for no limit
+	SendWindow  int // Seconds that sent mail counts towards the SendLimit
+	MailboxSize int // Mail a character can hold before the oldest read mail is removed, 0 for no limit
+	ReadExpiry  int // Days until read mail is removed, 0 to keep it
+}
+
 // DebugOptions holds various debug/temporary options for use while developing Erupe.
 type DebugOptions struct {
 	CleanDB             bool   // Automatically wipes the DB on server reset.
@@ -234,18 +287,22 @@ type Database struct {
 
 // Sign holds the sign server config.
 type Sign struct {
-	Enabled bool
-	Port    int
+	Enabled     bool
+	Port        int
+	TokenExpiry int // Seconds a sign token stays valid after it was last used, 0 disables expiry
 }
 
 // API holds server config
 type API struct {
-	Enabled     bool
-	Port        int
-	PatchServer string
-	Banners     []APISignBanner
-	Messages    []APISignMessage
-	Links       []APISignLink
+	Enabled              bool
+	Port                 int
+	PatchServer          string
+	RequireOpTOTP        bool // Operator accounts must enrol in two-factor authentication to log in
+	TOTPEnrollmentExpiry int  // Seconds an operator issued two-factor enrolment token is valid for
+	PasswordResetExpiry  int  // Seconds an operator issued password reset token is valid for
+	Banners              []APISignBanner
+	Messages             []APISignMessage
+	Links                []APISignLink
 }
 
 type APISignBanner struct {
@@ -305,6 +362,11 @@ func init() {
 	var err error
 	ErupeConfig, err = LoadConfig()
 	if err != nil {
+		if testing.Testing() {
+			// Tests run without a config.json
+			ErupeConfig = &Config{ClientMode: versionStrings[len(versionStrings)-1], RealClientMode: ZZ}
+			return
+		}
 		preventClose(fmt.Sprintf("Failed to load config: %s", err.Error()))
 	}
 }
@@ -328,6 +390,11 @@ func LoadConfig() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.AddConfigPath(".")
 
+	viper.SetDefault("TimeZone", 9)
+	viper.SetDefault("ShutdownDeadline", 30)
+	viper.SetDefault("API.TOTPEnrollmentExpiry", 86400)
+	viper.SetDefault("API.PasswordResetExpiry", 86400)
+
 	viper.SetDefault("DevModeOptions.SaveDumps", SaveDumpOptions{
 		Enabled:   true,
 		OutputDir: "save-backups",
@@ -348,13 +415,11 @@ func LoadConfig() (*Config, error) {
 		c.Host = getOutboundIP4().To4().String()
 	}
 
-	for i := range versionStrings {
-		if strings.ToUpper(c.ClientMode) == versionStrings[i] {
-			c.RealClientMode = Mode(i + 1)
-			c.ClientMode = strings.ToUpper(c.ClientMode)
-			if c.RealClientMode <= G101 {
-				c.ClientMode += " (Debug only)"
-			}
+	if mode, ok := ParseMode(c.ClientMode); ok {
+		c.RealClientMode = mode
+		c.ClientMode = strings.ToUpper(c.ClientMode)
+		if c.RealClientMode <= G101 {
+			c.ClientMode += " (Debug only)"
 		}
 	}
 	if c.RealClientMode == 0 {
@@ -370,7 +435,7 @@ func LoadConfig() (*Config, error) {
 }
 
 func preventClose(text string) {
-	if ErupeConfig.DisableSoftCrash {
+	if ErupeConfig != nil && ErupeConfig.DisableSoftCrash {
 		os.Exit(0)
 	}
 	fmt.Println("\nFailed to start Erupe:\n" + text)
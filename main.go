@@ -6,7 +6,9 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime/debug"
+	"sync"
 	"syscall"
 	"time"
 
@@ -138,6 +140,31 @@ func main() {
 		logger.Info("Database: Finished clearing")
 	}
 
+	// Quest files are shared by every channel and the API, so a quest pack swap applies to all of them.
+	binFiles := channelserver.NewBinFiles(config.BinPath)
+	if config.QuestPack != "" {
+		pack, err := channelserver.LoadQuestPack(filepath.Join(config.BinPath, config.QuestPack))
+		if err != nil {
+			logger.Error("Quest pack: Failed to load", zap.Error(err))
+		} else {
+			binFiles.Swap(pack)
+			logger.Info(fmt.Sprintf("Quest pack: Loaded %s", pack))
+		}
+	}
+
+	if config.ScanQuestFiles {
+		problems, err := channelserver.ScanQuestFiles(db, binFiles)
+		if err != nil {
+			logger.Error("Quest files: Failed to scan", zap.Error(err))
+		}
+		for _, problem := range problems {
+			logger.Warn(fmt.Sprintf("Quest files: %s %d unavailable at %s: %s", problem.Kind, problem.ID, problem.Path, problem.Error))
+		}
+		logger.Info(fmt.Sprintf("Quest files: Scan finished, %d problems found", len(problems)))
+	}
+
+	channelserver.ServerClock.SetLocation(time.FixedZone(fmt.Sprintf("UTC%+d", config.TimeZone), config.TimeZone*60*60))
+	channelserver.ServerClock.SetOffset(time.Duration(config.TimeOffset) * time.Second)
 	logger.Info(fmt.Sprintf("Server Time: %s", channelserver.TimeAdjusted().String()))
 
 	// Now start our server(s).
@@ -188,6 +215,7 @@ func main() {
 				Logger:      logger.Named("sign"),
 				ErupeConfig: _config.ErupeConfig,
 				DB:          db,
+				BinFiles:    binFiles,
 			})
 		err = ApiServer.Start()
 		if err != nil {
@@ -214,6 +242,7 @@ func main() {
 					ErupeConfig: _config.ErupeConfig,
 					DB:          db,
 					DiscordBot:  discordBot,
+					BinFiles:    binFiles,
 				})
 				if ee.IP == "" {
 					c.IP = config.Host
@@ -252,7 +281,21 @@ func main() {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 
+	// Stop new logins before warning connected players by chat. There is no server-initiated save
+	// request, so players must save themselves before their sessions are drained, and sessions
+	// that did not save after the warning are reported.
+	if config.Entrance.Enabled {
+		entranceServer.Drain()
+	}
+	if config.Sign.Enabled {
+		signServer.Drain()
+	}
+
+	warned := time.Now()
 	if !config.DisableSoftCrash {
+		for _, c := range channels {
+			c.BroadcastChatMessage("The server is shutting down, please save your progress.")
+		}
 		for i := 0; i < 10; i++ {
 			message := fmt.Sprintf("Shutting down in %d...", 10-i)
 			for _, c := range channels {
@@ -264,6 +307,20 @@ func main() {
 	}
 
 	if config.Channel.Enabled {
+		var wg sync.WaitGroup
+		for _, c := range channels {
+			wg.Add(1)
+			go func(c *channelserver.Server) {
+				defer wg.Done()
+				failures := c.Drain(time.Duration(config.ShutdownDeadline)*time.Second, warned)
+				for _, failure := range failures {
+					logger.Error("Failed to persist session on shutdown", zap.Uint16("channel", c.ID),
+						zap.Uint32("charID", failure.CharID), zap.String("name", failure.Name), zap.Error(failure.Err))
+				}
+				logger.Info(fmt.Sprintf("Channel %s drained, %d sessions failed to persist", c.GlobalID, len(failures)))
+			}(c)
+		}
+		wg.Wait()
 		for _, c := range channels {
 			c.Shutdown()
 		}
@@ -0,0 +1,17 @@
+BEGIN;
+
+-- A two week Conquest War rotation against Shantien (116), Disufiroa (107), Fatalis (2) and Crimson Fatalis (36)
+INSERT INTO public.earth_schedule (phase, status, duration, monsters)
+VALUES
+    (1, 1, 604800, '{116, 107, 2, 36}'),
+    (2, 2, 604800, '{116, 107, 2, 36}');
+
+-- Rewards are distributed to every contributing character once a phase ends
+INSERT INTO public.earth_rewards (phase, value_type, threshold, item_type, item_id, quantity)
+VALUES
+    (1, 1, 0, 7, 9647, 5),
+    (1, 1, 10000, 7, 11284, 4),
+    (2, 2, 0, 7, 9647, 5),
+    (2, 2, 10000, 7, 11381, 3);
+
+END;
@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.login_attempts
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer,
+    username text NOT NULL,
+    ip text NOT NULL,
+    success boolean NOT NULL,
+    locked boolean NOT NULL DEFAULT false,
+    attempted_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS login_attempts_username_idx ON public.login_attempts (username, attempted_at);
+CREATE INDEX IF NOT EXISTS login_attempts_ip_idx ON public.login_attempts (ip, attempted_at);
+
+END;
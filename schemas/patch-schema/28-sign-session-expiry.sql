@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE IF EXISTS public.sign_sessions ADD COLUMN IF NOT EXISTS created_at timestamp with time zone NOT NULL DEFAULT now();
+ALTER TABLE IF EXISTS public.sign_sessions ADD COLUMN IF NOT EXISTS expires timestamp with time zone;
+
+END;
@@ -0,0 +1,26 @@
+BEGIN;
+
+ALTER TABLE IF EXISTS public.users ADD COLUMN IF NOT EXISTS totp_secret text;
+ALTER TABLE IF EXISTS public.users ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false;
+ALTER TABLE IF EXISTS public.users ADD COLUMN IF NOT EXISTS totp_last_counter bigint;
+
+CREATE TABLE IF NOT EXISTS public.totp_recovery_codes
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer NOT NULL,
+    code text NOT NULL,
+    used_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.totp_enrollment_tokens
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer NOT NULL,
+    token text NOT NULL,
+    issued_by integer NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    expires timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+
+END;
@@ -0,0 +1,33 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.account_recovery_codes
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer NOT NULL,
+    code text NOT NULL,
+    used_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.password_reset_tokens
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer NOT NULL,
+    token text NOT NULL,
+    issued_by integer,
+    created_at timestamp with time zone NOT NULL DEFAULT now(),
+    expires timestamp with time zone NOT NULL,
+    used_at timestamp with time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.password_reset_log
+(
+    id serial NOT NULL PRIMARY KEY,
+    user_id integer,
+    method text NOT NULL,
+    issued_by integer,
+    ip text,
+    success boolean NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+END;
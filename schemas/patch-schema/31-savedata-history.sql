@@ -0,0 +1,19 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.savedata_history
+(
+    id serial NOT NULL PRIMARY KEY,
+    character_id integer NOT NULL,
+    savedata bytea NOT NULL,
+    size integer NOT NULL,
+    hr integer NOT NULL DEFAULT 0,
+    gr integer NOT NULL DEFAULT 0,
+    save_mode text,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS savedata_history_character_idx ON public.savedata_history (character_id, created_at);
+
+ALTER TABLE public.characters ADD COLUMN IF NOT EXISTS save_mode text;
+
+END;
@@ -0,0 +1,14 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.savedata_quarantine
+(
+    id serial NOT NULL PRIMARY KEY,
+    character_id integer NOT NULL,
+    savedata bytea NOT NULL,
+    reason text NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS savedata_quarantine_character_idx ON public.savedata_quarantine (character_id, created_at);
+
+END;
@@ -0,0 +1,9 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.server_maintenance
+(
+    server_id integer NOT NULL PRIMARY KEY,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+END;
@@ -0,0 +1,50 @@
+BEGIN;
+
+ALTER TYPE public.event_type ADD VALUE IF NOT EXISTS 'earth';
+
+END;
+
+-- New enum values can only be used once committed
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.earth_schedule
+(
+    id serial NOT NULL PRIMARY KEY,
+    phase integer NOT NULL,
+    status integer NOT NULL,
+    duration integer NOT NULL DEFAULT 604800,
+    monsters integer[] NOT NULL DEFAULT '{0,0,0,0}'
+);
+
+CREATE TABLE IF NOT EXISTS public.earth_values
+(
+    earth_id integer NOT NULL,
+    phase integer NOT NULL DEFAULT 0,
+    character_id integer NOT NULL,
+    value_type integer NOT NULL,
+    value bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (earth_id, phase, character_id, value_type)
+);
+
+CREATE TABLE IF NOT EXISTS public.earth_rewards
+(
+    id serial NOT NULL PRIMARY KEY,
+    phase integer NOT NULL,
+    value_type integer NOT NULL DEFAULT 1,
+    threshold bigint NOT NULL DEFAULT 0,
+    item_type integer NOT NULL,
+    item_id integer,
+    quantity integer
+);
+
+CREATE TABLE IF NOT EXISTS public.earth_rewards_granted
+(
+    earth_id integer NOT NULL,
+    phase integer NOT NULL,
+    granted_at timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (earth_id, phase)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS events_earth_start_idx ON public.events (start_time) WHERE event_type = 'earth';
+
+END;
@@ -0,0 +1,45 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.seibattle_timetable
+(
+    id serial NOT NULL PRIMARY KEY,
+    start_time timestamp with time zone NOT NULL,
+    end_time timestamp with time zone NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS seibattle_timetable_time_idx ON public.seibattle_timetable (start_time, end_time);
+
+CREATE TABLE IF NOT EXISTS public.seibattle_scores
+(
+    id serial NOT NULL PRIMARY KEY,
+    timetable_id integer NOT NULL,
+    character_id integer NOT NULL,
+    guild_id integer NOT NULL,
+    score bigint NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS seibattle_scores_timetable_idx ON public.seibattle_scores (timetable_id, guild_id);
+
+CREATE TABLE IF NOT EXISTS public.seibattle_results
+(
+    timetable_id integer NOT NULL,
+    guild_id integer NOT NULL,
+    opponent_id integer NOT NULL,
+    score bigint NOT NULL,
+    opponent_score bigint NOT NULL,
+    rank integer NOT NULL,
+    PRIMARY KEY (timetable_id, guild_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.seibattle_ranking_rewards
+(
+    id serial NOT NULL PRIMARY KEY,
+    rank_min integer NOT NULL,
+    rank_max integer NOT NULL,
+    item_type integer NOT NULL,
+    item_id integer NOT NULL,
+    quantity integer NOT NULL
+);
+
+END;
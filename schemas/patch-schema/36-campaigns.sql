@@ -0,0 +1,60 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.campaigns
+(
+    id serial NOT NULL PRIMARY KEY,
+    min_hr smallint NOT NULL DEFAULT -1,
+    max_hr smallint NOT NULL DEFAULT -1,
+    min_gr smallint NOT NULL DEFAULT -1,
+    max_gr smallint NOT NULL DEFAULT -1,
+    start_time timestamp with time zone NOT NULL,
+    end_time timestamp with time zone NOT NULL,
+    title text NOT NULL DEFAULT '',
+    reward text NOT NULL DEFAULT '',
+    description text NOT NULL DEFAULT '',
+    notes text NOT NULL DEFAULT '',
+    link text NOT NULL DEFAULT '',
+    prefix text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS public.campaign_categories
+(
+    id serial NOT NULL PRIMARY KEY,
+    type smallint NOT NULL DEFAULT 0,
+    title text NOT NULL DEFAULT '',
+    description text NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS public.campaign_category_links
+(
+    campaign_id integer NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    category_id integer NOT NULL REFERENCES campaign_categories(id) ON DELETE CASCADE,
+    PRIMARY KEY (campaign_id, category_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.campaign_rewards
+(
+    id serial NOT NULL PRIMARY KEY,
+    campaign_id integer NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    item_type integer NOT NULL,
+    item_id integer NOT NULL,
+    quantity integer NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.campaign_entries
+(
+    campaign_id integer NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE,
+    character_id integer NOT NULL,
+    entered_at timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (campaign_id, character_id)
+);
+
+CREATE TABLE IF NOT EXISTS public.campaign_claims
+(
+    reward_id integer NOT NULL REFERENCES campaign_rewards(id) ON DELETE CASCADE,
+    character_id integer NOT NULL,
+    claimed_at timestamp with time zone NOT NULL DEFAULT now(),
+    PRIMARY KEY (reward_id, character_id)
+);
+
+END;
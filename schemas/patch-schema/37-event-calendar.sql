@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.event_calendar
+(
+    id serial NOT NULL PRIMARY KEY,
+    event_type integer NOT NULL,
+    start_time timestamp with time zone NOT NULL,
+    end_time timestamp with time zone NOT NULL,
+    param1 integer NOT NULL DEFAULT 0,
+    param2 integer NOT NULL DEFAULT 0,
+    param3 integer NOT NULL DEFAULT 0,
+    param4 integer NOT NULL DEFAULT 0,
+    quest_ids integer[] NOT NULL DEFAULT '{}',
+    event_quest_type integer
+);
+
+END;
@@ -0,0 +1,15 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.quest_tune_values
+(
+    id serial NOT NULL PRIMARY KEY,
+    tune_id integer NOT NULL,
+    value integer NOT NULL,
+    span integer NOT NULL DEFAULT 1,
+    start_time timestamp with time zone,
+    end_time timestamp with time zone,
+    course_id smallint,
+    description text NOT NULL DEFAULT ''
+);
+
+END;
@@ -0,0 +1,33 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.mail_templates
+(
+    id serial NOT NULL PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    subject text NOT NULL,
+    body text NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.mail_broadcasts
+(
+    id serial NOT NULL PRIMARY KEY,
+    target text NOT NULL,
+    target_id integer NOT NULL DEFAULT 0,
+    template_id integer REFERENCES mail_templates(id) ON DELETE SET NULL,
+    subject text NOT NULL DEFAULT '',
+    body text NOT NULL DEFAULT '',
+    attached_item integer NOT NULL DEFAULT 0,
+    attached_item_amount integer NOT NULL DEFAULT 0,
+    send_at timestamp with time zone NOT NULL DEFAULT now(),
+    sent_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+ALTER TABLE mail ADD COLUMN IF NOT EXISTS broadcast_id integer;
+
+CREATE INDEX IF NOT EXISTS mail_broadcast_id_idx ON mail (broadcast_id);
+
+-- System mail has no sending character
+ALTER TABLE public.mail ALTER COLUMN sender_id DROP NOT NULL;
+
+END;
@@ -0,0 +1,15 @@
+BEGIN;
+
+-- Each mail sent by a character, guild mail counting once, for the send limit
+CREATE TABLE IF NOT EXISTS public.mail_sends
+(
+    id serial NOT NULL PRIMARY KEY,
+    character_id integer NOT NULL,
+    sent_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS mail_sends_character_idx ON public.mail_sends (character_id, sent_at);
+
+CREATE INDEX IF NOT EXISTS mail_recipient_idx ON mail (recipient_id) WHERE deleted = false;
+
+END;
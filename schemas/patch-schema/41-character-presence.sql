@@ -0,0 +1,17 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS public.character_presence
+(
+    character_id integer NOT NULL PRIMARY KEY,
+    online boolean NOT NULL DEFAULT false,
+    server_id integer NOT NULL,
+    world integer NOT NULL,
+    channel integer NOT NULL,
+    stage_id text NOT NULL DEFAULT '',
+    in_quest boolean NOT NULL DEFAULT false,
+    last_seen timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS character_presence_online_idx ON character_presence (server_id) WHERE online = true;
+
+END;
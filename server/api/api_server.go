@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	_config "erupe-ce/config"
+	"erupe-ce/server/channelserver"
 	"fmt"
 	"net/http"
 	"os"
@@ -19,6 +20,7 @@ type Config struct {
 	Logger      *zap.Logger
 	DB          *sqlx.DB
 	ErupeConfig *_config.Config
+	BinFiles    *channelserver.BinFiles
 }
 
 // APIServer is Erupes Standard API interface
@@ -27,6 +29,7 @@ type APIServer struct {
 	logger         *zap.Logger
 	erupeConfig    *_config.Config
 	db             *sqlx.DB
+	binFiles       *channelserver.BinFiles
 	httpServer     *http.Server
 	isShuttingDown bool
 }
@@ -37,8 +40,12 @@ func NewAPIServer(config *Config) *APIServer {
 		logger:      config.Logger,
 		erupeConfig: config.ErupeConfig,
 		db:          config.DB,
+		binFiles:    config.BinFiles,
 		httpServer:  &http.Server{},
 	}
+	if s.binFiles == nil {
+		s.binFiles = channelserver.NewBinFiles(s.erupeConfig.BinPath)
+	}
 	return s
 }
 
@@ -49,9 +56,40 @@ func (s *APIServer) Start() error {
 	r.HandleFunc("/launcher", s.Launcher)
 	r.HandleFunc("/login", s.Login)
 	r.HandleFunc("/register", s.Register)
+	r.HandleFunc("/logout", s.Logout)
+	r.HandleFunc("/totp/enroll", s.TOTPEnroll)
+	r.HandleFunc("/totp/verify", s.TOTPVerify)
+	r.HandleFunc("/totp/recovery", s.TOTPRecovery)
+	r.HandleFunc("/totp/disable", s.TOTPDisable)
 	r.HandleFunc("/character/create", s.CreateCharacter)
 	r.HandleFunc("/character/delete", s.DeleteCharacter)
 	r.HandleFunc("/character/export", s.ExportSave)
+	r.HandleFunc("/character/lists", s.CharacterLists)
+	r.HandleFunc("/account/recovery-codes", s.AccountRecoveryCodes)
+	r.HandleFunc("/account/reset-password", s.ResetPassword)
+	r.HandleFunc("/admin/login-attempts", s.LoginAttempts)
+	r.HandleFunc("/admin/reset-token", s.IssueResetToken)
+	r.HandleFunc("/admin/totp-enrollment-token", s.IssueTOTPEnrollmentToken)
+	r.HandleFunc("/admin/character/restore", s.RestoreCharacter)
+	r.HandleFunc("/admin/character/transfer", s.TransferCharacter)
+	r.HandleFunc("/admin/character/rename", s.RenameCharacter)
+	r.HandleFunc("/admin/character/import", s.ImportSave)
+	r.HandleFunc("/admin/character/history", s.SaveHistory)
+	r.HandleFunc("/admin/migrate-saves", s.MigrateSaves)
+	r.HandleFunc("/admin/maintenance", s.Maintenance)
+	r.HandleFunc("/admin/quest-files", s.QuestFiles)
+	r.HandleFunc("/admin/campaigns", s.Campaigns)
+	r.HandleFunc("/admin/campaign", s.SaveCampaign)
+	r.HandleFunc("/admin/campaign/category", s.SaveCampaignCategory)
+	r.HandleFunc("/admin/events", s.Events)
+	r.HandleFunc("/admin/tune-values", s.TuneValues)
+	r.HandleFunc("/admin/distribution", s.CreateDistribution)
+	r.HandleFunc("/admin/distributions", s.Distributions)
+	r.HandleFunc("/admin/mail/send", s.SendMail)
+	r.HandleFunc("/admin/mail/broadcasts", s.MailBroadcasts)
+	r.HandleFunc("/admin/mail/templates", s.MailTemplates)
+	r.HandleFunc("/admin/presence", s.Presence)
+	r.HandleFunc("/admin/character/rollback", s.RollbackSave)
 	r.HandleFunc("/api/ss/bbs/upload.php", s.ScreenShot)
 	r.HandleFunc("/api/ss/bbs/{id}", s.ScreenShotGet)
 	handler := handlers.CORS(handlers.AllowedHeaders([]string{"Content-Type"}))(r)
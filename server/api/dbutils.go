@@ -2,11 +2,21 @@ package api
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"erupe-ce/common/token"
+	"erupe-ce/common/totp"
+	"erupe-ce/server/channelserver"
+	"erupe-ce/server/lockout"
 	"fmt"
 	"time"
 
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -34,17 +44,32 @@ func (s *APIServer) createNewUser(ctx context.Context, username string, password
 
 func (s *APIServer) createLoginToken(ctx context.Context, uid uint32) (uint32, string, error) {
 	loginToken := token.Generate(16)
+	var expiry *time.Time
+	if s.erupeConfig.Sign.TokenExpiry > 0 {
+		t := time.Now().Add(time.Duration(s.erupeConfig.Sign.TokenExpiry) * time.Second)
+		expiry = &t
+	}
 	var tid uint32
-	err := s.db.QueryRowContext(ctx, "INSERT INTO sign_sessions (user_id, token) VALUES ($1, $2) RETURNING id", uid, loginToken).Scan(&tid)
+	err := s.db.QueryRowContext(ctx, "INSERT INTO sign_sessions (user_id, token, expires) VALUES ($1, $2, $3) RETURNING id", uid, loginToken, expiry).Scan(&tid)
 	if err != nil {
 		return 0, "", err
 	}
 	return tid, loginToken, nil
 }
 
+func (s *APIServer) revokeLoginToken(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sign_sessions WHERE token = $1", token)
+	return err
+}
+
+func (s *APIServer) revokeUserTokens(ctx context.Context, uid uint32) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM sign_sessions WHERE user_id = $1", uid)
+	return err
+}
+
 func (s *APIServer) userIDFromToken(ctx context.Context, token string) (uint32, error) {
 	var userID uint32
-	err := s.db.QueryRowContext(ctx, "SELECT user_id FROM sign_sessions WHERE token = $1", token).Scan(&userID)
+	err := s.db.QueryRowContext(ctx, "SELECT user_id FROM sign_sessions WHERE token = $1 AND (expires IS NULL OR expires > now())", token).Scan(&userID)
 	if err == sql.ErrNoRows {
 		return 0, fmt.Errorf("invalid login token")
 	} else if err != nil {
@@ -55,17 +80,26 @@ func (s *APIServer) userIDFromToken(ctx context.Context, token string) (uint32,
 
 func (s *APIServer) createCharacter(ctx context.Context, userID uint32) (Character, error) {
 	var character Character
-	err := s.db.GetContext(ctx, &character,
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return character, err
+	}
+	defer tx.Rollback()
+	// Lock the account so that restores and transfers see the new character in their slot check
+	if _, err = tx.ExecContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		return character, err
+	}
+	err = tx.GetContext(ctx, &character,
 		"SELECT id, name, is_female, weapon_type, hr, gr, last_login FROM characters WHERE is_new_character = true AND user_id = $1 LIMIT 1",
 		userID,
 	)
 	if err == sql.ErrNoRows {
 		var count int
-		s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM characters WHERE user_id = $1", userID).Scan(&count)
+		tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM characters WHERE user_id = $1", userID).Scan(&count)
 		if count >= 16 {
 			return character, fmt.Errorf("cannot have more than 16 characters")
 		}
-		err = s.db.GetContext(ctx, &character, `
+		err = tx.GetContext(ctx, &character, `
 			INSERT INTO characters (
 				user_id, is_female, is_new_character, name, unk_desc_string,
 				hr, gr, weapon_type, last_login
@@ -75,7 +109,10 @@ func (s *APIServer) createCharacter(ctx context.Context, userID uint32) (Charact
 			userID, uint32(time.Now().Unix()),
 		)
 	}
-	return character, err
+	if err != nil {
+		return character, err
+	}
+	return character, tx.Commit()
 }
 
 func (s *APIServer) deleteCharacter(ctx context.Context, userID uint32, charID uint32) error {
@@ -133,3 +170,602 @@ func (s *APIServer) exportSave(ctx context.Context, uid uint32, cid uint32) (map
 	}
 	return result, nil
 }
+
+func (s *APIServer) loginLocked(ctx context.Context, username string, ip string) bool {
+	return lockout.Locked(ctx, s.db, s.erupeConfig.LoginLockout, username, ip)
+}
+
+func (s *APIServer) recordLoginAttempt(ctx context.Context, uid uint32, username string, ip string, success bool, locked bool) {
+	if err := lockout.Record(ctx, s.db, uid, username, ip, success, locked); err != nil {
+		s.logger.Error("Failed to record login attempt", zap.Error(err))
+	}
+}
+
+func (s *APIServer) isOp(ctx context.Context, uid uint32) bool {
+	var op bool
+	err := s.db.QueryRowContext(ctx, "SELECT COALESCE(op, false) FROM users WHERE id = $1", uid).Scan(&op)
+	return err == nil && op
+}
+
+func (s *APIServer) getLoginAttempts(ctx context.Context, username string, ip string, limit int) ([]LoginAttempt, error) {
+	attempts := make([]LoginAttempt, 0)
+	err := s.db.SelectContext(ctx, &attempts, `
+		SELECT id, COALESCE(user_id, 0) AS user_id, username, ip, success, locked, attempted_at
+		FROM login_attempts
+		WHERE ($1 = '' OR username = $1) AND ($2 = '' OR ip = $2)
+		ORDER BY attempted_at DESC LIMIT $3`,
+		username, ip, limit,
+	)
+	return attempts, err
+}
+
+// authenticate checks the credentials of a user, returning the code to respond with if they are rejected.
+func (s *APIServer) authenticate(ctx context.Context, username string, password string, ip string) (uint32, uint32, string, error) {
+	if s.loginLocked(ctx, username, ip) {
+		s.recordLoginAttempt(ctx, 0, username, ip, false, true)
+		return 0, 0, "locked-error", nil
+	}
+	var (
+		userID     uint32
+		userRights uint32
+		passwordDB string
+	)
+	err := s.db.QueryRowContext(ctx, "SELECT id, password, rights FROM users WHERE username = $1", username).Scan(&userID, &passwordDB, &userRights)
+	if err == sql.ErrNoRows {
+		s.recordLoginAttempt(ctx, 0, username, ip, false, false)
+		return 0, 0, "username-error", nil
+	} else if err != nil {
+		return 0, 0, "", err
+	}
+	if bcrypt.CompareHashAndPassword([]byte(passwordDB), []byte(password)) != nil {
+		s.recordLoginAttempt(ctx, userID, username, ip, false, false)
+		return 0, 0, "password-error", nil
+	}
+	return userID, userRights, "", nil
+}
+
+var (
+	errTOTPEnabled = errors.New("two-factor authentication is already enabled")
+	errTOTPCode    = errors.New("invalid two-factor authentication code")
+)
+
+// checkTOTP returns the code to respond with if the second factor of a login is missing or invalid.
+func (s *APIServer) checkTOTP(ctx context.Context, uid uint32, code string) (string, error) {
+	var (
+		enabled bool
+		secret  string
+		op      bool
+	)
+	err := s.db.QueryRowContext(ctx, "SELECT totp_enabled, COALESCE(totp_secret, ''), COALESCE(op, false) FROM users WHERE id = $1", uid).Scan(&enabled, &secret, &op)
+	if err != nil {
+		return "", err
+	}
+	if !enabled {
+		if op && s.erupeConfig.API.RequireOpTOTP {
+			return "totp-enrollment-required", nil
+		}
+		return "", nil
+	}
+	if code == "" {
+		return "totp-required", nil
+	}
+	accepted, err := s.acceptTOTP(ctx, uid, secret, code)
+	if err != nil {
+		return "", err
+	} else if accepted {
+		return "", nil
+	}
+	used, err := s.useRecoveryCode(ctx, totpRecoveryCodes, uid, code)
+	if err != nil {
+		return "", err
+	} else if used {
+		return "", nil
+	}
+	return "totp-error", nil
+}
+
+// acceptTOTP reports whether code is valid for the secret of a user and newer than the last code they used,
+// so that a code cannot be replayed within its validity window.
+func (s *APIServer) acceptTOTP(ctx context.Context, uid uint32, secret string, code string) (bool, error) {
+	step, ok := totp.Match(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	result, err := s.db.ExecContext(ctx, "UPDATE users SET totp_last_counter = $2 WHERE id = $1 AND COALESCE(totp_last_counter, -1) < $2", uid, int64(step))
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *APIServer) enrollTOTP(ctx context.Context, uid uint32) (string, error) {
+	var enabled bool
+	err := s.db.QueryRowContext(ctx, "SELECT totp_enabled FROM users WHERE id = $1", uid).Scan(&enabled)
+	if err != nil {
+		return "", err
+	} else if enabled {
+		return "", errTOTPEnabled
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE users SET totp_secret = $1 WHERE id = $2", secret, uid)
+	return secret, err
+}
+
+func (s *APIServer) verifyTOTP(ctx context.Context, uid uint32, code string) ([]string, error) {
+	var (
+		enabled bool
+		secret  string
+	)
+	err := s.db.QueryRowContext(ctx, "SELECT totp_enabled, COALESCE(totp_secret, '') FROM users WHERE id = $1", uid).Scan(&enabled, &secret)
+	if err != nil {
+		return nil, err
+	} else if enabled {
+		return nil, errTOTPEnabled
+	} else if secret == "" {
+		return nil, errTOTPCode
+	}
+	accepted, err := s.acceptTOTP(ctx, uid, secret, code)
+	if err != nil {
+		return nil, err
+	} else if !accepted {
+		return nil, errTOTPCode
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE users SET totp_enabled = true WHERE id = $1", uid)
+	if err != nil {
+		return nil, err
+	}
+	return s.createRecoveryCodes(ctx, totpRecoveryCodes, uid)
+}
+
+func (s *APIServer) createEnrollmentToken(ctx context.Context, uid uint32, issuedBy uint32) (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	enrollmentToken := hex.EncodeToString(b)
+	hash := sha256.Sum256([]byte(enrollmentToken))
+	expiry := time.Now().Add(time.Duration(s.erupeConfig.API.TOTPEnrollmentExpiry) * time.Second)
+	_, err := s.db.ExecContext(ctx, "INSERT INTO totp_enrollment_tokens (user_id, token, issued_by, expires) VALUES ($1, $2, $3, $4)",
+		uid, hex.EncodeToString(hash[:]), issuedBy, expiry)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return enrollmentToken, expiry, nil
+}
+
+func (s *APIServer) useEnrollmentToken(ctx context.Context, uid uint32, enrollmentToken string) (bool, error) {
+	hash := sha256.Sum256([]byte(enrollmentToken))
+	result, err := s.db.ExecContext(ctx, `UPDATE totp_enrollment_tokens SET used_at = now()
+		WHERE user_id = $1 AND token = $2 AND used_at IS NULL AND expires > now()`, uid, hex.EncodeToString(hash[:]))
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// enrollmentAllowed reports whether a user may enrol in two-factor authentication. The password alone is
+// not enough, as whoever holds a leaked password could enrol their own secret. A login token of the user
+// or an enrolment token issued by an operator is also required.
+func (s *APIServer) enrollmentAllowed(ctx context.Context, uid uint32, loginToken string, enrollmentToken string) (bool, error) {
+	if loginToken != "" {
+		tokenUserID, err := s.userIDFromToken(ctx, loginToken)
+		if err == nil && tokenUserID == uid {
+			return true, nil
+		}
+	}
+	if enrollmentToken != "" {
+		return s.useEnrollmentToken(ctx, uid, enrollmentToken)
+	}
+	return false, nil
+}
+
+func (s *APIServer) disableTOTP(ctx context.Context, uid uint32) error {
+	_, err := s.db.ExecContext(ctx, "UPDATE users SET totp_enabled = false, totp_secret = NULL WHERE id = $1", uid)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE user_id = $1", uid)
+	return err
+}
+
+const (
+	totpRecoveryCodes    = "totp_recovery_codes"
+	accountRecoveryCodes = "account_recovery_codes"
+)
+
+// createRecoveryCodes replaces the recovery codes of a user in the given table, returning the new codes in plain text.
+func (s *APIServer) createRecoveryCodes(ctx context.Context, table string, uid uint32) ([]string, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE user_id = $1", table), uid)
+	if err != nil {
+		return nil, err
+	}
+	codes := make([]string, 10)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err = rand.Read(b); err != nil {
+			return nil, err
+		}
+		codes[i] = fmt.Sprintf("%x-%x-%x-%x", b[:3], b[3:5], b[5:8], b[8:])
+		hash, err := bcrypt.GenerateFromPassword([]byte(codes[i]), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (user_id, code) VALUES ($1, $2)", table), uid, string(hash))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+func (s *APIServer) useRecoveryCode(ctx context.Context, table string, uid uint32, code string) (bool, error) {
+	var recoveryCodes []struct {
+		ID   uint32 `db:"id"`
+		Code string `db:"code"`
+	}
+	err := s.db.SelectContext(ctx, &recoveryCodes, fmt.Sprintf("SELECT id, code FROM %s WHERE user_id = $1 AND used_at IS NULL", table), uid)
+	if err != nil {
+		return false, err
+	}
+	for _, recoveryCode := range recoveryCodes {
+		if bcrypt.CompareHashAndPassword([]byte(recoveryCode.Code), []byte(code)) == nil {
+			result, err := s.db.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET used_at = now() WHERE id = $1 AND used_at IS NULL", table), recoveryCode.ID)
+			if err != nil {
+				return false, err
+			}
+			n, _ := result.RowsAffected()
+			return n > 0, nil
+		}
+	}
+	return false, nil
+}
+
+var errResetCode = errors.New("invalid password reset code")
+
+func (s *APIServer) createResetToken(ctx context.Context, uid uint32, issuedBy uint32) (string, time.Time, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", time.Time{}, err
+	}
+	resetToken := hex.EncodeToString(b)
+	hash := sha256.Sum256([]byte(resetToken))
+	expiry := time.Now().Add(time.Duration(s.erupeConfig.API.PasswordResetExpiry) * time.Second)
+	_, err := s.db.ExecContext(ctx, "INSERT INTO password_reset_tokens (user_id, token, issued_by, expires) VALUES ($1, $2, $3, $4)",
+		uid, hex.EncodeToString(hash[:]), issuedBy, expiry)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	s.logPasswordReset(ctx, uid, "token-issued", issuedBy, "", true)
+	return resetToken, expiry, nil
+}
+
+func (s *APIServer) useResetToken(ctx context.Context, uid uint32, resetToken string) (uint32, bool, error) {
+	hash := sha256.Sum256([]byte(resetToken))
+	var issuedBy uint32
+	err := s.db.QueryRowContext(ctx, `UPDATE password_reset_tokens SET used_at = now()
+		WHERE user_id = $1 AND token = $2 AND used_at IS NULL AND expires > now() RETURNING COALESCE(issued_by, 0)`,
+		uid, hex.EncodeToString(hash[:])).Scan(&issuedBy)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return issuedBy, true, nil
+}
+
+// resetPassword sets a new password if the code is a valid recovery code or reset token, revoking all login tokens.
+func (s *APIServer) resetPassword(ctx context.Context, uid uint32, code string, password string, ip string) error {
+	method := "recovery-code"
+	used, err := s.useRecoveryCode(ctx, accountRecoveryCodes, uid, code)
+	if err != nil {
+		return err
+	}
+	var issuedBy uint32
+	if !used {
+		method = "reset-token"
+		issuedBy, used, err = s.useResetToken(ctx, uid, code)
+		if err != nil {
+			return err
+		}
+	}
+	if !used {
+		s.logPasswordReset(ctx, uid, "invalid-code", 0, ip, false)
+		return errResetCode
+	}
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, "UPDATE users SET password = $1 WHERE id = $2", string(passwordHash), uid)
+	if err != nil {
+		return err
+	}
+	s.logPasswordReset(ctx, uid, method, issuedBy, ip, true)
+	return s.revokeUserTokens(ctx, uid)
+}
+
+func (s *APIServer) logPasswordReset(ctx context.Context, uid uint32, method string, issuedBy uint32, ip string, success bool) {
+	s.logger.Info("Password reset", zap.Uint32("userID", uid), zap.String("method", method), zap.Uint32("issuedBy", issuedBy), zap.String("ip", ip), zap.Bool("success", success))
+	_, err := s.db.ExecContext(ctx, "INSERT INTO password_reset_log (user_id, method, issued_by, ip, success) VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, ''), $5)",
+		uid, method, issuedBy, ip, success)
+	if err != nil {
+		s.logger.Error("Failed to log password reset", zap.Error(err))
+	}
+}
+
+var (
+	errCharacterOnline = errors.New("character is online")
+	errCharacterLimit  = errors.New("cannot have more than 16 characters")
+)
+
+func (s *APIServer) characterOnline(ctx context.Context, charID uint32) (bool, error) {
+	var online int
+	err := s.db.QueryRowContext(ctx, "SELECT count(*) FROM sign_sessions WHERE char_id = $1", charID).Scan(&online)
+	return online > 0, err
+}
+
+func (s *APIServer) ownsCharacter(ctx context.Context, uid uint32, charID uint32) bool {
+	var owned bool
+	s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM characters WHERE id = $1 AND user_id = $2 AND deleted = false)", charID, uid).Scan(&owned)
+	return owned
+}
+
+// checkCharacterSlots locks the user's account for the rest of the transaction and returns
+// errCharacterLimit if they have no free character slot.
+func checkCharacterSlots(ctx context.Context, tx *sqlx.Tx, userID uint32) error {
+	var count int
+	_, err := tx.ExecContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE", userID)
+	if err != nil {
+		return err
+	}
+	err = tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM characters WHERE user_id = $1 AND deleted = false", userID).Scan(&count)
+	if err != nil {
+		return err
+	} else if count >= 16 {
+		return errCharacterLimit
+	}
+	return nil
+}
+
+func (s *APIServer) restoreCharacter(ctx context.Context, charID uint32) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var userID uint32
+	err = tx.QueryRowContext(ctx, "SELECT user_id FROM characters WHERE id = $1 AND deleted = true", charID).Scan(&userID)
+	if err != nil {
+		return err
+	}
+	if err = checkCharacterSlots(ctx, tx, userID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE characters SET deleted = false WHERE id = $1 AND deleted = true", charID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+func (s *APIServer) transferCharacter(ctx context.Context, charID uint32, userID uint32) error {
+	if online, err := s.characterOnline(ctx, charID); err != nil {
+		return err
+	} else if online {
+		return errCharacterOnline
+	}
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = checkCharacterSlots(ctx, tx, userID); err != nil {
+		return err
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE characters SET user_id = $1 WHERE id = $2 AND is_new_character = false", userID, charID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+func (s *APIServer) renameCharacter(ctx context.Context, charID uint32, name string) error {
+	if online, err := s.characterOnline(ctx, charID); err != nil {
+		return err
+	} else if online {
+		return errCharacterOnline
+	}
+	return channelserver.RenameCharacter(s.db, charID, name)
+}
+
+func (s *APIServer) importSave(ctx context.Context, charID uint32, export *channelserver.SaveExport) error {
+	if online, err := s.characterOnline(ctx, charID); err != nil {
+		return err
+	} else if online {
+		return errCharacterOnline
+	}
+	return channelserver.ImportCharacterSave(s.db, charID, export)
+}
+
+func (s *APIServer) restoreSaveHistory(ctx context.Context, charID uint32, historyID uint32) error {
+	if online, err := s.characterOnline(ctx, charID); err != nil {
+		return err
+	} else if online {
+		return errCharacterOnline
+	}
+	return channelserver.RestoreSaveHistory(s.db, charID, historyID)
+}
+
+// channelServerIDs returns the server IDs of a configured world and channel, numbered from 1.
+// A channel of 0 selects every channel of the world.
+func (s *APIServer) channelServerIDs(world int, channel int) ([]uint16, bool) {
+	entries := s.erupeConfig.Entrance.Entries
+	if world < 1 || world > len(entries) || channel < 0 || channel > len(entries[world-1].Channels) {
+		return nil, false
+	}
+	var serverIDs []uint16
+	for i := range entries[world-1].Channels {
+		if channel == 0 || channel == i+1 {
+			serverIDs = append(serverIDs, uint16(((world-1)<<8|4096)+(i|16)))
+		}
+	}
+	return serverIDs, true
+}
+
+func (s *APIServer) setMaintenance(ctx context.Context, serverIDs []uint16, enabled bool) error {
+	for _, sid := range serverIDs {
+		var err error
+		if enabled {
+			_, err = s.db.ExecContext(ctx, "INSERT INTO server_maintenance (server_id) VALUES ($1) ON CONFLICT DO NOTHING", sid)
+		} else {
+			_, err = s.db.ExecContext(ctx, "DELETE FROM server_maintenance WHERE server_id = $1", sid)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *APIServer) getMaintenance(ctx context.Context) ([]MaintenanceChannel, error) {
+	channels := make([]MaintenanceChannel, 0)
+	rows, err := s.db.QueryContext(ctx, "SELECT server_id FROM server_maintenance ORDER BY server_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sid uint16
+		if err = rows.Scan(&sid); err != nil {
+			return nil, err
+		}
+		world, index := int(sid-4096)>>8, int(sid&0xFF)-16
+		channel := MaintenanceChannel{ServerID: sid, World: world + 1, Channel: index + 1}
+		if world < len(s.erupeConfig.Entrance.Entries) {
+			channel.Name = s.erupeConfig.Entrance.Entries[world].Name
+		}
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+func (s *APIServer) getCampaigns(ctx context.Context) (Campaigns, error) {
+	campaigns := Campaigns{Campaigns: []Campaign{}, Categories: []CampaignCategory{}}
+	err := s.db.SelectContext(ctx, &campaigns.Campaigns, `SELECT c.id, min_hr, max_hr, min_gr, max_gr, start_time, end_time,
+		title, reward, description, notes, link, prefix, (SELECT COUNT(*) FROM campaign_entries e WHERE e.campaign_id = c.id) AS entries
+		FROM campaigns c ORDER BY c.id`)
+	if err != nil {
+		return campaigns, err
+	}
+	for i := range campaigns.Campaigns {
+		campaign := &campaigns.Campaigns[i]
+		campaign.Categories = []uint16{}
+		campaign.Rewards = []CampaignItem{}
+		err = s.db.SelectContext(ctx, &campaign.Categories, "SELECT category_id FROM campaign_category_links WHERE campaign_id = $1 ORDER BY category_id", campaign.ID)
+		if err != nil {
+			return campaigns, err
+		}
+		err = s.db.SelectContext(ctx, &campaign.Rewards, `SELECT r.id, item_type, item_id, quantity,
+			(SELECT COUNT(*) FROM campaign_claims c WHERE c.reward_id = r.id) AS claims FROM campaign_rewards r WHERE campaign_id = $1 ORDER BY r.id`, campaign.ID)
+		if err != nil {
+			return campaigns, err
+		}
+	}
+	err = s.db.SelectContext(ctx, &campaigns.Categories, "SELECT id, type, title, description FROM campaign_categories ORDER BY id")
+	return campaigns, err
+}
+
+// saveCampaign creates or updates a campaign. Rewards without an ID are added and rewards missing from
+// the campaign are removed, while existing rewards keep their claims.
+func (s *APIServer) saveCampaign(ctx context.Context, c Campaign) (uint32, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	if c.ID == 0 {
+		err = tx.QueryRowContext(ctx, `INSERT INTO campaigns (min_hr, max_hr, min_gr, max_gr, start_time, end_time,
+			title, reward, description, notes, link, prefix) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12) RETURNING id`,
+			c.MinHR, c.MaxHR, c.MinGR, c.MaxGR, c.Start, c.End, c.Title, c.Reward, c.Description, c.Notes, c.Link, c.Prefix).Scan(&c.ID)
+	} else {
+		_, err = tx.ExecContext(ctx, `UPDATE campaigns SET min_hr = $2, max_hr = $3, min_gr = $4, max_gr = $5,
+			start_time = $6, end_time = $7, title = $8, reward = $9, description = $10, notes = $11, link = $12, prefix = $13 WHERE id = $1`,
+			c.ID, c.MinHR, c.MaxHR, c.MinGR, c.MaxGR, c.Start, c.End, c.Title, c.Reward, c.Description, c.Notes, c.Link, c.Prefix)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM campaign_category_links WHERE campaign_id = $1", c.ID); err != nil {
+		return 0, err
+	}
+	for _, category := range c.Categories {
+		if _, err = tx.ExecContext(ctx, "INSERT INTO campaign_category_links (campaign_id, category_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", c.ID, category); err != nil {
+			return 0, err
+		}
+	}
+	keep := []int64{}
+	for _, reward := range c.Rewards {
+		if reward.ID == 0 {
+			err = tx.QueryRowContext(ctx, "INSERT INTO campaign_rewards (campaign_id, item_type, item_id, quantity) VALUES ($1, $2, $3, $4) RETURNING id",
+				c.ID, reward.ItemType, reward.ItemID, reward.Quantity).Scan(&reward.ID)
+		} else {
+			_, err = tx.ExecContext(ctx, "UPDATE campaign_rewards SET item_type = $3, item_id = $4, quantity = $5 WHERE id = $1 AND campaign_id = $2",
+				reward.ID, c.ID, reward.ItemType, reward.ItemID, reward.Quantity)
+		}
+		if err != nil {
+			return 0, err
+		}
+		keep = append(keep, int64(reward.ID))
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM campaign_rewards WHERE campaign_id = $1 AND NOT (id = ANY($2))", c.ID, pq.Int64Array(keep)); err != nil {
+		return 0, err
+	}
+	return c.ID, tx.Commit()
+}
+
+func (s *APIServer) getEvents(ctx context.Context) ([]CalendarEvent, error) {
+	events := make([]CalendarEvent, 0)
+	rows, err := s.db.QueryContext(ctx, `SELECT id, event_type, start_time, end_time, param1, param2, param3, param4, quest_ids, event_quest_type
+		FROM event_calendar ORDER BY start_time, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e CalendarEvent
+		err = rows.Scan(&e.ID, &e.EventType, &e.Start, &e.End, &e.Params[0], &e.Params[1], &e.Params[2], &e.Params[3], &e.QuestIDs, &e.EventQuestType)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (s *APIServer) saveEvent(ctx context.Context, e *CalendarEvent) error {
+	if e.ID == 0 {
+		return s.db.QueryRowContext(ctx, `INSERT INTO event_calendar (event_type, start_time, end_time, param1, param2, param3, param4, quest_ids, event_quest_type)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9) RETURNING id`,
+			e.EventType, e.Start, e.End, e.Params[0], e.Params[1], e.Params[2], e.Params[3], e.QuestIDs, e.EventQuestType).Scan(&e.ID)
+	}
+	_, err := s.db.ExecContext(ctx, `UPDATE event_calendar SET event_type = $2, start_time = $3, end_time = $4, param1 = $5, param2 = $6,
+		param3 = $7, param4 = $8, quest_ids = $9, event_quest_type = $10 WHERE id = $1`,
+		e.ID, e.EventType, e.Start, e.End, e.Params[0], e.Params[1], e.Params[2], e.Params[3], e.QuestIDs, e.EventQuestType)
+	return err
+}
@@ -1,10 +1,12 @@
 package api
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"encoding/xml"
 	"errors"
+	"erupe-ce/common/totp"
 	_config "erupe-ce/config"
 	"erupe-ce/server/channelserver"
 	"fmt"
@@ -21,7 +23,6 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/lib/pq"
 	"go.uber.org/zap"
-	"golang.org/x/crypto/bcrypt"
 )
 
 const (
@@ -71,8 +72,127 @@ type AuthData struct {
 	PatchServer   string      `json:"patchServer"`
 }
 
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TOTPRecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type PasswordRecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type TOTPEnrollmentToken struct {
+	EnrollmentToken string `json:"enrollmentToken"`
+	Expires         int64  `json:"expires"`
+}
+
+type ResetToken struct {
+	ResetToken string `json:"resetToken"`
+	Expires    int64  `json:"expires"`
+}
+
+type LoginAttempt struct {
+	ID          uint32    `json:"id"`
+	UserID      uint32    `json:"userId" db:"user_id"`
+	Username    string    `json:"username"`
+	IP          string    `json:"ip"`
+	Success     bool      `json:"success"`
+	Locked      bool      `json:"locked"`
+	AttemptedAt time.Time `json:"attemptedAt" db:"attempted_at"`
+}
+
+type MaintenanceChannel struct {
+	ServerID uint16 `json:"serverId"`
+	World    int    `json:"world"`
+	Channel  int    `json:"channel"`
+	Name     string `json:"name"`
+}
+
+type Campaign struct {
+	ID          uint32         `json:"id" db:"id"`
+	MinHR       int16          `json:"minHr" db:"min_hr"`
+	MaxHR       int16          `json:"maxHr" db:"max_hr"`
+	MinGR       int16          `json:"minGr" db:"min_gr"`
+	MaxGR       int16          `json:"maxGr" db:"max_gr"`
+	Start       time.Time      `json:"start" db:"start_time"`
+	End         time.Time      `json:"end" db:"end_time"`
+	Title       string         `json:"title" db:"title"`
+	Reward      string         `json:"reward" db:"reward"`
+	Description string         `json:"description" db:"description"`
+	Notes       string         `json:"notes" db:"notes"`
+	Link        string         `json:"link" db:"link"`
+	Prefix      string         `json:"prefix" db:"prefix"`
+	Entries     int            `json:"entries" db:"entries"`
+	Categories  []uint16       `json:"categories"`
+	Rewards     []CampaignItem `json:"rewards"`
+}
+
+type CampaignItem struct {
+	ID       uint32 `json:"id" db:"id"`
+	ItemType uint16 `json:"itemType" db:"item_type"`
+	ItemID   uint16 `json:"itemId" db:"item_id"`
+	Quantity uint16 `json:"quantity" db:"quantity"`
+	Claims   int    `json:"claims" db:"claims"`
+}
+
+type CampaignCategory struct {
+	ID          uint16 `json:"id" db:"id"`
+	Type        uint8  `json:"type" db:"type"`
+	Title       string `json:"title" db:"title"`
+	Description string `json:"description" db:"description"`
+}
+
+type Campaigns struct {
+	Campaigns  []Campaign         `json:"campaigns"`
+	Categories []CampaignCategory `json:"categories"`
+}
+
+// CalendarEvent is an event_calendar row. Clients are sent Start and End as the Unk5 and Unk6 fields
+// of MsgMhfEnumerateEvent, whose meaning as the event window is a guess; the server itself only
+// uses them to decide which events are listed.
+type CalendarEvent struct {
+	ID             uint32        `json:"id" db:"id"`
+	EventType      uint16        `json:"eventType" db:"event_type"`
+	Start          time.Time     `json:"start" db:"start_time"`
+	End            time.Time     `json:"end" db:"end_time"`
+	Params         [4]uint16     `json:"params"`
+	QuestIDs       pq.Int32Array `json:"questIds" db:"quest_ids"`
+	EventQuestType *int          `json:"eventQuestType" db:"event_quest_type"`
+}
+
+type TuneValue struct {
+	ID          uint32     `json:"id" db:"id"`
+	TuneID      uint16     `json:"tuneId" db:"tune_id"`
+	Value       uint16     `json:"value" db:"value"`
+	Span        uint16     `json:"span" db:"span"`
+	Start       *time.Time `json:"start" db:"start_time"`
+	End         *time.Time `json:"end" db:"end_time"`
+	CourseID    *uint16    `json:"courseId" db:"course_id"`
+	Description string     `json:"description" db:"description"`
+}
+
+type MailTemplate struct {
+	ID      uint32 `json:"id" db:"id"`
+	Name    string `json:"name" db:"name"`
+	Subject string `json:"subject" db:"subject"`
+	Body    string `json:"body" db:"body"`
+}
+
+type SaveHistoryEntry struct {
+	ID        uint32 `json:"id"`
+	Size      int    `json:"size"`
+	HR        uint16 `json:"hr"`
+	GR        uint16 `json:"gr"`
+	CreatedAt int64  `json:"createdAt"`
+}
+
 type ExportData struct {
-	Character map[string]interface{} `json:"character"`
+	Character map[string]interface{}    `json:"character"`
+	Save      *channelserver.SaveExport `json:"save"`
 }
 
 func (s *APIServer) newAuthData(userID uint32, userRights uint32, userTokenID uint32, userToken string, characters []Character) AuthData {
@@ -126,32 +246,27 @@ func (s *APIServer) Login(w http.ResponseWriter, r *http.Request) {
 	var reqData struct {
 		Username string `json:"username"`
 		Password string `json:"password"`
+		Code     string `json:"code"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
 		s.logger.Error("JSON decode error", zap.Error(err))
 		w.WriteHeader(400)
 		return
 	}
-	var (
-		userID     uint32
-		userRights uint32
-		password   string
-	)
-	err := s.db.QueryRow("SELECT id, password, rights FROM users WHERE username = $1", reqData.Username).Scan(&userID, &password, &userRights)
-	if err == sql.ErrNoRows {
-		w.WriteHeader(400)
-		w.Write([]byte("username-error"))
-		return
-	} else if err != nil {
+	ip := remoteIP(r)
+	userID, userRights, authErr, err := s.authenticate(ctx, reqData.Username, reqData.Password, ip)
+	if err != nil {
 		s.logger.Warn("SQL query error", zap.Error(err))
 		w.WriteHeader(500)
 		return
+	} else if authErr != "" {
+		writeAuthError(w, authErr)
+		return
 	}
-	if bcrypt.CompareHashAndPassword([]byte(password), []byte(reqData.Password)) != nil {
-		w.WriteHeader(400)
-		w.Write([]byte("password-error"))
+	if !s.checkSecondFactor(ctx, w, userID, reqData.Username, ip, reqData.Code) {
 		return
 	}
+	s.recordLoginAttempt(ctx, userID, reqData.Username, ip, true, false)
 
 	userTokenID, userToken, err := s.createLoginToken(ctx, userID)
 	if err != nil {
@@ -173,6 +288,263 @@ func (s *APIServer) Login(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(respData)
 }
 
+// checkSecondFactor responds and returns false if the second factor of a request is missing or invalid.
+// Invalid codes count as failed login attempts so that they cannot be brute forced past the lockout.
+func (s *APIServer) checkSecondFactor(ctx context.Context, w http.ResponseWriter, userID uint32, username string, ip string, code string) bool {
+	totpErr, err := s.checkTOTP(ctx, userID, code)
+	if err != nil {
+		s.logger.Warn("Error checking TOTP", zap.Error(err))
+		w.WriteHeader(500)
+		return false
+	} else if totpErr != "" {
+		if totpErr == "totp-error" {
+			s.recordLoginAttempt(ctx, userID, username, ip, false, false)
+		}
+		writeAuthError(w, totpErr)
+		return false
+	}
+	return true
+}
+
+func (s *APIServer) TOTPEnroll(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Username        string `json:"username"`
+		Password        string `json:"password"`
+		Token           string `json:"token"`
+		EnrollmentToken string `json:"enrollmentToken"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	ip := remoteIP(r)
+	userID, _, authErr, err := s.authenticate(ctx, reqData.Username, reqData.Password, ip)
+	if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	} else if authErr != "" {
+		writeAuthError(w, authErr)
+		return
+	}
+	allowed, err := s.enrollmentAllowed(ctx, userID, reqData.Token, reqData.EnrollmentToken)
+	if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	} else if !allowed {
+		s.recordLoginAttempt(ctx, userID, reqData.Username, ip, false, false)
+		writeAuthError(w, "totp-enrollment-token-error")
+		return
+	}
+	secret, err := s.enrollTOTP(ctx, userID)
+	if err == errTOTPEnabled {
+		w.WriteHeader(400)
+		w.Write([]byte("totp-enabled-error"))
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to enrol TOTP", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI("Erupe", reqData.Username, secret),
+	})
+}
+
+func (s *APIServer) TOTPVerify(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	ip := remoteIP(r)
+	userID, _, authErr, err := s.authenticate(ctx, reqData.Username, reqData.Password, ip)
+	if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	} else if authErr != "" {
+		writeAuthError(w, authErr)
+		return
+	}
+	recoveryCodes, err := s.verifyTOTP(ctx, userID, reqData.Code)
+	if err == errTOTPCode {
+		s.recordLoginAttempt(ctx, userID, reqData.Username, ip, false, false)
+		w.WriteHeader(400)
+		w.Write([]byte("totp-error"))
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to verify TOTP", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPRecoveryCodes{RecoveryCodes: recoveryCodes})
+}
+
+func (s *APIServer) TOTPRecovery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	ip := remoteIP(r)
+	userID, _, authErr, err := s.authenticate(ctx, reqData.Username, reqData.Password, ip)
+	if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	} else if authErr != "" {
+		writeAuthError(w, authErr)
+		return
+	}
+	if !s.checkSecondFactor(ctx, w, userID, reqData.Username, ip, reqData.Code) {
+		return
+	}
+	recoveryCodes, err := s.createRecoveryCodes(ctx, totpRecoveryCodes, userID)
+	if err != nil {
+		s.logger.Error("Failed to create recovery codes", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPRecoveryCodes{RecoveryCodes: recoveryCodes})
+}
+
+func (s *APIServer) TOTPDisable(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	ip := remoteIP(r)
+	userID, _, authErr, err := s.authenticate(ctx, reqData.Username, reqData.Password, ip)
+	if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	} else if authErr != "" {
+		writeAuthError(w, authErr)
+		return
+	}
+	if !s.checkSecondFactor(ctx, w, userID, reqData.Username, ip, reqData.Code) {
+		return
+	}
+	if err = s.disableTOTP(ctx, userID); err != nil {
+		s.logger.Error("Failed to disable TOTP", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+func (s *APIServer) AccountRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	// Recovery codes can reset the password, so they need the same proof as a login
+	ip := remoteIP(r)
+	userID, _, authErr, err := s.authenticate(ctx, reqData.Username, reqData.Password, ip)
+	if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	} else if authErr != "" {
+		writeAuthError(w, authErr)
+		return
+	}
+	if !s.checkSecondFactor(ctx, w, userID, reqData.Username, ip, reqData.Code) {
+		return
+	}
+	recoveryCodes, err := s.createRecoveryCodes(ctx, accountRecoveryCodes, userID)
+	if err != nil {
+		s.logger.Error("Failed to create recovery codes", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(PasswordRecoveryCodes{RecoveryCodes: recoveryCodes})
+}
+
+func (s *APIServer) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Username string `json:"username"`
+		Code     string `json:"code"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	if reqData.Password == "" {
+		w.WriteHeader(400)
+		return
+	}
+	ip := remoteIP(r)
+	if s.loginLocked(ctx, reqData.Username, ip) {
+		s.recordLoginAttempt(ctx, 0, reqData.Username, ip, false, true)
+		writeAuthError(w, "locked-error")
+		return
+	}
+	var userID uint32
+	err := s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", reqData.Username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		s.recordLoginAttempt(ctx, 0, reqData.Username, ip, false, false)
+		writeAuthError(w, "username-error")
+		return
+	} else if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	err = s.resetPassword(ctx, userID, reqData.Code, reqData.Password, ip)
+	if err == errResetCode {
+		s.recordLoginAttempt(ctx, userID, reqData.Username, ip, false, false)
+		writeAuthError(w, "reset-error")
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to reset password", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
 func (s *APIServer) Register(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var reqData struct {
@@ -213,6 +585,36 @@ func (s *APIServer) Register(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(respData)
 }
 
+func (s *APIServer) Logout(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token string `json:"token"`
+		All   bool   `json:"all"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if reqData.All {
+		err = s.revokeUserTokens(ctx, userID)
+	} else {
+		err = s.revokeLoginToken(ctx, reqData.Token)
+	}
+	if err != nil {
+		s.logger.Error("Failed to revoke login token", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
 func (s *APIServer) CreateCharacter(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var reqData struct {
@@ -289,28 +691,480 @@ func (s *APIServer) ExportSave(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(500)
 		return
 	}
+	export, err := channelserver.ExportCharacterSave(s.db, reqData.CharID)
+	if err != nil {
+		s.logger.Error("Failed to decode save", zap.Error(err), zap.Uint32("charID", reqData.CharID))
+		w.WriteHeader(500)
+		return
+	}
 	save := ExportData{
 		Character: character,
+		Save:      export,
 	}
 	w.Header().Add("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(save)
 }
-func (s *APIServer) ScreenShotGet(w http.ResponseWriter, r *http.Request) {
-	// Get the 'id' parameter from the URL
-	token := mux.Vars(r)["id"]
-	var tokenPattern = regexp.MustCompile(`[A-Za-z0-9]+`)
-
-	if !tokenPattern.MatchString(token) || token == "" {
-		http.Error(w, "Not Valid Token", http.StatusBadRequest)
-
+func (s *APIServer) LoginAttempts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token    string `json:"token"`
+		Username string `json:"username"`
+		IP       string `json:"ip"`
+		Limit    int    `json:"limit"`
 	}
-	// Open the image file
-	safePath := s.erupeConfig.Screenshots.OutputDir
-	path := filepath.Join(safePath, fmt.Sprintf("%s.jpg", token))
-	result, err := verifyPath(path, safePath)
-
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
 	if err != nil {
-		fmt.Println("Error " + err.Error())
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	if reqData.Limit <= 0 || reqData.Limit > 1000 {
+		reqData.Limit = 100
+	}
+	attempts, err := s.getLoginAttempts(ctx, reqData.Username, reqData.IP, reqData.Limit)
+	if err != nil {
+		s.logger.Error("Failed to get login attempts", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(attempts)
+}
+
+func (s *APIServer) IssueResetToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token    string `json:"token"`
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	opID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, opID) {
+		w.WriteHeader(403)
+		return
+	}
+	var userID uint32
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", reqData.Username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(400)
+		w.Write([]byte("username-error"))
+		return
+	} else if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	resetToken, expiry, err := s.createResetToken(ctx, userID, opID)
+	if err != nil {
+		s.logger.Error("Failed to create reset token", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ResetToken{
+		ResetToken: resetToken,
+		Expires:    expiry.Unix(),
+	})
+}
+
+// IssueTOTPEnrollmentToken lets an operator issue the token another account needs to enrol in
+// two-factor authentication when it cannot log in without it.
+func (s *APIServer) IssueTOTPEnrollmentToken(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token    string `json:"token"`
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	opID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, opID) {
+		w.WriteHeader(403)
+		return
+	}
+	var userID uint32
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", reqData.Username).Scan(&userID)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(400)
+		w.Write([]byte("username-error"))
+		return
+	} else if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	if userID == opID {
+		// Enrolment must be vouched for by another operator
+		w.WriteHeader(403)
+		return
+	}
+	enrollmentToken, expiry, err := s.createEnrollmentToken(ctx, userID, opID)
+	if err != nil {
+		s.logger.Error("Failed to create enrolment token", zap.Error(err), zap.Uint32("userID", userID))
+		w.WriteHeader(500)
+		return
+	}
+	s.logger.Info("Issued TOTP enrolment token", zap.Uint32("userID", userID), zap.Uint32("opID", opID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TOTPEnrollmentToken{
+		EnrollmentToken: enrollmentToken,
+		Expires:         expiry.Unix(),
+	})
+}
+
+// writeCharacterError responds with the code for an admin character operation error.
+func (s *APIServer) writeCharacterError(w http.ResponseWriter, err error, charID uint32) {
+	switch err {
+	case sql.ErrNoRows:
+		w.WriteHeader(404)
+	case errCharacterOnline:
+		w.WriteHeader(409)
+		w.Write([]byte("character-online-error"))
+	case errCharacterLimit:
+		w.WriteHeader(400)
+		w.Write([]byte("character-limit-error"))
+	case channelserver.ErrSaveModeMismatch:
+		w.WriteHeader(400)
+		w.Write([]byte("mode-error"))
+	default:
+		s.logger.Error("Failed to update character", zap.Error(err), zap.Uint32("charID", charID))
+		w.WriteHeader(500)
+	}
+}
+
+func (s *APIServer) RestoreCharacter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token  string `json:"token"`
+		CharID uint32 `json:"charId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	if err = s.restoreCharacter(ctx, reqData.CharID); err != nil {
+		s.writeCharacterError(w, err, reqData.CharID)
+		return
+	}
+	s.logger.Info("Restored character", zap.Uint32("charID", reqData.CharID), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+func (s *APIServer) TransferCharacter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token    string `json:"token"`
+		CharID   uint32 `json:"charId"`
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	var targetID uint32
+	err = s.db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", reqData.Username).Scan(&targetID)
+	if err == sql.ErrNoRows {
+		w.WriteHeader(400)
+		w.Write([]byte("username-error"))
+		return
+	} else if err != nil {
+		s.logger.Warn("SQL query error", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	if err = s.transferCharacter(ctx, reqData.CharID, targetID); err != nil {
+		s.writeCharacterError(w, err, reqData.CharID)
+		return
+	}
+	s.logger.Info("Transferred character", zap.Uint32("charID", reqData.CharID), zap.Uint32("userID", targetID), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+func (s *APIServer) RenameCharacter(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token  string `json:"token"`
+		CharID uint32 `json:"charId"`
+		Name   string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	if err = s.renameCharacter(ctx, reqData.CharID, reqData.Name); err == channelserver.ErrInvalidName {
+		w.WriteHeader(400)
+		w.Write([]byte("name-error"))
+		return
+	} else if err == channelserver.ErrInvalidSave {
+		w.WriteHeader(400)
+		w.Write([]byte("save-error"))
+		return
+	} else if err != nil {
+		s.writeCharacterError(w, err, reqData.CharID)
+		return
+	}
+	s.logger.Info("Renamed character", zap.Uint32("charID", reqData.CharID), zap.String("name", reqData.Name), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+func (s *APIServer) ImportSave(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token  string                   `json:"token"`
+		CharID uint32                   `json:"charId"`
+		Save   channelserver.SaveExport `json:"save"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	if err = s.importSave(ctx, reqData.CharID, &reqData.Save); errors.Is(err, channelserver.ErrInvalidSave) {
+		w.WriteHeader(400)
+		w.Write([]byte("save-error"))
+		return
+	} else if err == channelserver.ErrInvalidName {
+		w.WriteHeader(400)
+		w.Write([]byte("name-error"))
+		return
+	} else if err != nil {
+		s.writeCharacterError(w, err, reqData.CharID)
+		return
+	}
+	s.logger.Info("Imported save", zap.Uint32("charID", reqData.CharID), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+func (s *APIServer) MigrateSaves(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token  string `json:"token"`
+		From   string `json:"from"`
+		To     string `json:"to"`
+		CharID uint32 `json:"charId"`
+		DryRun bool   `json:"dryRun"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	from, ok := _config.ParseMode(reqData.From)
+	to, ok2 := _config.ParseMode(reqData.To)
+	if !ok || !ok2 {
+		w.WriteHeader(400)
+		w.Write([]byte("mode-error"))
+		return
+	}
+	reports, err := channelserver.MigrateSaves(s.db, from, to, reqData.CharID, reqData.DryRun)
+	if errors.Is(err, channelserver.ErrInvalidSave) {
+		w.WriteHeader(400)
+		w.Write([]byte("mode-error"))
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to migrate saves", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	s.logger.Info("Migrated saves", zap.String("from", from.String()), zap.String("to", to.String()),
+		zap.Int("count", len(reports)), zap.Bool("dryRun", reqData.DryRun), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+func (s *APIServer) QuestFiles(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	problems, err := channelserver.ScanQuestFiles(s.db, s.binFiles)
+	if err != nil {
+		s.logger.Error("Failed to scan quest files", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	if problems == nil {
+		problems = []channelserver.QuestFileProblem{}
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(problems)
+}
+
+func (s *APIServer) SaveHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token  string `json:"token"`
+		CharID uint32 `json:"charId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	entries, err := channelserver.GetSaveHistory(s.db, reqData.CharID)
+	if err != nil {
+		s.logger.Error("Failed to get save history", zap.Error(err), zap.Uint32("charID", reqData.CharID))
+		w.WriteHeader(500)
+		return
+	}
+	history := make([]SaveHistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		history = append(history, SaveHistoryEntry{
+			ID:        entry.ID,
+			Size:      entry.Size,
+			HR:        entry.HR,
+			GR:        entry.GR,
+			CreatedAt: entry.CreatedAt.Unix(),
+		})
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(history)
+}
+
+func (s *APIServer) RollbackSave(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token     string `json:"token"`
+		CharID    uint32 `json:"charId"`
+		HistoryID uint32 `json:"historyId"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	if err = s.restoreSaveHistory(ctx, reqData.CharID, reqData.HistoryID); err != nil {
+		s.writeCharacterError(w, err, reqData.CharID)
+		return
+	}
+	s.logger.Info("Restored savedata", zap.Uint32("charID", reqData.CharID), zap.Uint32("historyID", reqData.HistoryID), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct{}{})
+}
+
+func (s *APIServer) ScreenShotGet(w http.ResponseWriter, r *http.Request) {
+	// Get the 'id' parameter from the URL
+	token := mux.Vars(r)["id"]
+	var tokenPattern = regexp.MustCompile(`[A-Za-z0-9]+`)
+
+	if !tokenPattern.MatchString(token) || token == "" {
+		http.Error(w, "Not Valid Token", http.StatusBadRequest)
+
+	}
+	// Open the image file
+	safePath := s.erupeConfig.Screenshots.OutputDir
+	path := filepath.Join(safePath, fmt.Sprintf("%s.jpg", token))
+	result, err := verifyPath(path, safePath)
+
+	if err != nil {
+		fmt.Println("Error " + err.Error())
 	} else {
 		fmt.Println("Canonical: " + result)
 
@@ -319,94 +1173,677 @@ func (s *APIServer) ScreenShotGet(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Image not found", http.StatusNotFound)
 			return
 		}
-		defer file.Close()
-		// Set content type header to image/jpeg
-		w.Header().Set("Content-Type", "image/jpeg")
-		// Copy the image content to the response writer
-		if _, err := io.Copy(w, file); err != nil {
-			http.Error(w, "Unable to send image", http.StatusInternalServerError)
+		defer file.Close()
+		// Set content type header to image/jpeg
+		w.Header().Set("Content-Type", "image/jpeg")
+		// Copy the image content to the response writer
+		if _, err := io.Copy(w, file); err != nil {
+			http.Error(w, "Unable to send image", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+func (s *APIServer) ScreenShot(w http.ResponseWriter, r *http.Request) {
+	// Create a struct representing the XML result
+	type Result struct {
+		XMLName xml.Name `xml:"result"`
+		Code    string   `xml:"code"`
+	}
+	// Set the Content-Type header to specify that the response is in XML format
+	w.Header().Set("Content-Type", "text/xml")
+	result := Result{Code: "200"}
+	if !s.erupeConfig.Screenshots.Enabled {
+		result = Result{Code: "400"}
+	} else {
+
+		if r.Method != http.MethodPost {
+			result = Result{Code: "405"}
+		}
+		// Get File from Request
+		file, _, err := r.FormFile("img")
+		if err != nil {
+			result = Result{Code: "400"}
+		}
+		var tokenPattern = regexp.MustCompile(`[A-Za-z0-9]+`)
+		token := r.FormValue("token")
+		if !tokenPattern.MatchString(token) || token == "" {
+			result = Result{Code: "401"}
+
+		}
+
+		// Validate file
+		img, _, err := image.Decode(file)
+		if err != nil {
+			result = Result{Code: "400"}
+		}
+
+		safePath := s.erupeConfig.Screenshots.OutputDir
+
+		path := filepath.Join(safePath, fmt.Sprintf("%s.jpg", token))
+		verified, err := verifyPath(path, safePath)
+
+		if err != nil {
+			result = Result{Code: "500"}
+		} else {
+
+			_, err = os.Stat(safePath)
+			if err != nil {
+				if os.IsNotExist(err) {
+					err = os.MkdirAll(safePath, os.ModePerm)
+					if err != nil {
+						s.logger.Error("Error writing screenshot, could not create folder")
+						result = Result{Code: "500"}
+					}
+				} else {
+					s.logger.Error("Error writing screenshot")
+					result = Result{Code: "500"}
+				}
+			}
+			// Create or open the output file
+			outputFile, err := os.Create(verified)
+			if err != nil {
+				result = Result{Code: "500"}
+			}
+			defer outputFile.Close()
+
+			// Encode the image and write it to the file
+			err = jpeg.Encode(outputFile, img, &jpeg.Options{Quality: s.erupeConfig.Screenshots.UploadQuality})
+			if err != nil {
+				s.logger.Error("Error writing screenshot, could not write file", zap.Error(err))
+				result = Result{Code: "500"}
+			}
+		}
+	}
+	// Marshal the struct into XML
+	xmlData, err := xml.Marshal(result)
+	if err != nil {
+		http.Error(w, "Unable to marshal XML", http.StatusInternalServerError)
+		return
+	}
+	// Write the XML response with a 200 status code
+	w.WriteHeader(http.StatusOK)
+	w.Write(xmlData)
+}
+
+func (s *APIServer) Maintenance(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token   string `json:"token"`
+		World   int    `json:"world"`
+		Channel int    `json:"channel"`
+		Enabled *bool  `json:"enabled"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	if reqData.Enabled != nil {
+		serverIDs, ok := s.channelServerIDs(reqData.World, reqData.Channel)
+		if !ok {
+			w.WriteHeader(400)
+			w.Write([]byte("channel-error"))
+			return
+		}
+		if err = s.setMaintenance(ctx, serverIDs, *reqData.Enabled); err != nil {
+			s.logger.Error("Failed to set maintenance", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+		s.logger.Info("Set maintenance", zap.Int("world", reqData.World), zap.Int("channel", reqData.Channel),
+			zap.Bool("enabled", *reqData.Enabled), zap.Uint32("opID", userID))
+	}
+	channels, err := s.getMaintenance(ctx)
+	if err != nil {
+		s.logger.Error("Failed to get maintenance", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+func (s *APIServer) Campaigns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	campaigns, err := s.getCampaigns(ctx)
+	if err != nil {
+		s.logger.Error("Failed to get campaigns", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(campaigns)
+}
+
+func (s *APIServer) SaveCampaign(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token    string   `json:"token"`
+		Campaign Campaign `json:"campaign"`
+		Delete   bool     `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	campaign := reqData.Campaign
+	if reqData.Delete {
+		if _, err = s.db.ExecContext(ctx, "DELETE FROM campaigns WHERE id = $1", campaign.ID); err != nil {
+			s.logger.Error("Failed to delete campaign", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+		s.logger.Info("Deleted campaign", zap.Uint32("campaignID", campaign.ID), zap.Uint32("opID", userID))
+		w.WriteHeader(200)
+		return
+	}
+	gate := func(min, max int16) bool {
+		return min < 0 || max < 0 || min <= max
+	}
+	if !campaign.End.After(campaign.Start) || !gate(campaign.MinHR, campaign.MaxHR) || !gate(campaign.MinGR, campaign.MaxGR) {
+		w.WriteHeader(400)
+		w.Write([]byte("campaign-error"))
+		return
+	}
+	campaign.ID, err = s.saveCampaign(ctx, campaign)
+	if err != nil {
+		s.logger.Error("Failed to save campaign", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	s.logger.Info("Saved campaign", zap.Uint32("campaignID", campaign.ID), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(campaign)
+}
+
+func (s *APIServer) SaveCampaignCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token    string           `json:"token"`
+		Category CampaignCategory `json:"category"`
+		Delete   bool             `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	category := reqData.Category
+	if reqData.Delete {
+		_, err = s.db.ExecContext(ctx, "DELETE FROM campaign_categories WHERE id = $1", category.ID)
+	} else if category.ID == 0 {
+		err = s.db.QueryRowContext(ctx, "INSERT INTO campaign_categories (type, title, description) VALUES ($1, $2, $3) RETURNING id",
+			category.Type, category.Title, category.Description).Scan(&category.ID)
+	} else {
+		_, err = s.db.ExecContext(ctx, "UPDATE campaign_categories SET type = $2, title = $3, description = $4 WHERE id = $1",
+			category.ID, category.Type, category.Title, category.Description)
+	}
+	if err != nil {
+		s.logger.Error("Failed to save campaign category", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	s.logger.Info("Saved campaign category", zap.Uint16("categoryID", category.ID), zap.Bool("delete", reqData.Delete), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(category)
+}
+
+func (s *APIServer) Events(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token  string        `json:"token"`
+		Event  CalendarEvent `json:"event"`
+		Save   bool          `json:"save"`
+		Delete bool          `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	event := reqData.Event
+	if reqData.Delete {
+		if _, err = s.db.ExecContext(ctx, "DELETE FROM event_calendar WHERE id = $1", event.ID); err != nil {
+			s.logger.Error("Failed to delete event", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+		s.logger.Info("Deleted event", zap.Uint32("eventID", event.ID), zap.Uint32("opID", userID))
+	} else if reqData.Save {
+		if !event.End.After(event.Start) || len(event.QuestIDs) > 255 {
+			w.WriteHeader(400)
+			w.Write([]byte("event-error"))
+			return
+		}
+		if event.QuestIDs == nil {
+			event.QuestIDs = pq.Int32Array{}
+		}
+		if err = s.saveEvent(ctx, &event); err != nil {
+			s.logger.Error("Failed to save event", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+		s.logger.Info("Saved event", zap.Uint32("eventID", event.ID), zap.Uint32("opID", userID))
+	}
+	events, err := s.getEvents(ctx)
+	if err != nil {
+		s.logger.Error("Failed to get events", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+func (s *APIServer) TuneValues(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token     string    `json:"token"`
+		TuneValue TuneValue `json:"tuneValue"`
+		Save      bool      `json:"save"`
+		Delete    bool      `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	tv := reqData.TuneValue
+	if reqData.Delete {
+		if _, err = s.db.ExecContext(ctx, "DELETE FROM quest_tune_values WHERE id = $1", tv.ID); err != nil {
+			s.logger.Error("Failed to delete tune value", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+		s.logger.Info("Deleted tune value", zap.Uint32("id", tv.ID), zap.Uint32("opID", userID))
+	} else if reqData.Save {
+		if tv.Span == 0 {
+			tv.Span = 1
+		}
+		if (tv.Start != nil && tv.End != nil && !tv.End.After(*tv.Start)) || uint32(tv.TuneID)+uint32(tv.Span) > 0xFFFF || (tv.CourseID != nil && *tv.CourseID > 31) {
+			w.WriteHeader(400)
+			w.Write([]byte("tune-error"))
 			return
 		}
-	}
-}
-func (s *APIServer) ScreenShot(w http.ResponseWriter, r *http.Request) {
-	// Create a struct representing the XML result
-	type Result struct {
-		XMLName xml.Name `xml:"result"`
-		Code    string   `xml:"code"`
-	}
-	// Set the Content-Type header to specify that the response is in XML format
-	w.Header().Set("Content-Type", "text/xml")
-	result := Result{Code: "200"}
-	if !s.erupeConfig.Screenshots.Enabled {
-		result = Result{Code: "400"}
-	} else {
-
-		if r.Method != http.MethodPost {
-			result = Result{Code: "405"}
+		if tv.ID == 0 {
+			err = s.db.QueryRowContext(ctx, `INSERT INTO quest_tune_values (tune_id, value, span, start_time, end_time, course_id, description)
+				VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`, tv.TuneID, tv.Value, tv.Span, tv.Start, tv.End, tv.CourseID, tv.Description).Scan(&tv.ID)
+		} else {
+			_, err = s.db.ExecContext(ctx, `UPDATE quest_tune_values SET tune_id = $2, value = $3, span = $4, start_time = $5, end_time = $6,
+				course_id = $7, description = $8 WHERE id = $1`, tv.ID, tv.TuneID, tv.Value, tv.Span, tv.Start, tv.End, tv.CourseID, tv.Description)
 		}
-		// Get File from Request
-		file, _, err := r.FormFile("img")
 		if err != nil {
-			result = Result{Code: "400"}
+			s.logger.Error("Failed to save tune value", zap.Error(err))
+			w.WriteHeader(500)
+			return
 		}
-		var tokenPattern = regexp.MustCompile(`[A-Za-z0-9]+`)
-		token := r.FormValue("token")
-		if !tokenPattern.MatchString(token) || token == "" {
-			result = Result{Code: "401"}
+		s.logger.Info("Saved tune value", zap.Uint32("id", tv.ID), zap.Uint16("tuneID", tv.TuneID), zap.Uint16("value", tv.Value), zap.Uint32("opID", userID))
+	}
+	tuneValues := make([]TuneValue, 0)
+	err = s.db.SelectContext(ctx, &tuneValues, `SELECT id, tune_id, value, span, start_time, end_time, course_id, description
+		FROM quest_tune_values ORDER BY tune_id, id`)
+	if err != nil {
+		s.logger.Error("Failed to get tune values", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tuneValues)
+}
 
-		}
+func (s *APIServer) CreateDistribution(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token        string                        `json:"token"`
+		Distribution channelserver.NewDistribution `json:"distribution"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	recipients, err := channelserver.CreateDistribution(s.db, reqData.Distribution)
+	if errors.Is(err, channelserver.ErrInvalidDistribution) {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to create distribution", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	s.logger.Info("Created distribution", zap.String("target", reqData.Distribution.Target), zap.Uint32("targetID", reqData.Distribution.TargetID),
+		zap.Int("recipients", recipients), zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"recipients": recipients})
+}
 
-		// Validate file
-		img, _, err := image.Decode(file)
-		if err != nil {
-			result = Result{Code: "400"}
+func (s *APIServer) Distributions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token          string `json:"token"`
+		DistributionID uint32 `json:"distributionId"`
+		Limit          int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	var resp interface{}
+	if reqData.DistributionID > 0 {
+		resp, err = channelserver.GetDistributionClaims(s.db, reqData.DistributionID)
+	} else {
+		if reqData.Limit <= 0 || reqData.Limit > 500 {
+			reqData.Limit = 100
 		}
+		resp, err = channelserver.GetDistributions(s.db, reqData.Limit)
+	}
+	if err != nil {
+		s.logger.Error("Failed to get distributions", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
 
-		safePath := s.erupeConfig.Screenshots.OutputDir
+func (s *APIServer) SendMail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token string                      `json:"token"`
+		Mail  channelserver.MailBroadcast `json:"mail"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	id, err := channelserver.ScheduleMail(s.db, reqData.Mail)
+	if errors.Is(err, channelserver.ErrInvalidMail) {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to schedule mail", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	s.logger.Info("Scheduled mail", zap.Uint32("id", id), zap.String("target", reqData.Mail.Target), zap.Uint32("targetID", reqData.Mail.TargetID),
+		zap.Uint32("opID", userID))
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]uint32{"id": id})
+}
 
-		path := filepath.Join(safePath, fmt.Sprintf("%s.jpg", token))
-		verified, err := verifyPath(path, safePath)
+func (s *APIServer) MailBroadcasts(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token string `json:"token"`
+		Limit int    `json:"limit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	if reqData.Limit <= 0 || reqData.Limit > 500 {
+		reqData.Limit = 100
+	}
+	broadcasts, err := channelserver.GetMailBroadcasts(s.db, reqData.Limit)
+	if err != nil {
+		s.logger.Error("Failed to get mail broadcasts", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(broadcasts)
+}
 
-		if err != nil {
-			result = Result{Code: "500"}
+func (s *APIServer) MailTemplates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token    string       `json:"token"`
+		Template MailTemplate `json:"template"`
+		Save     bool         `json:"save"`
+		Delete   bool         `json:"delete"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	t := reqData.Template
+	if reqData.Delete {
+		if _, err = s.db.ExecContext(ctx, "DELETE FROM mail_templates WHERE id = $1", t.ID); err != nil {
+			s.logger.Error("Failed to delete mail template", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+		s.logger.Info("Deleted mail template", zap.Uint32("id", t.ID), zap.Uint32("opID", userID))
+	} else if reqData.Save {
+		if t.Name == "" || t.Subject == "" {
+			w.WriteHeader(400)
+			w.Write([]byte("template-error"))
+			return
+		}
+		if t.ID == 0 {
+			err = s.db.QueryRowContext(ctx, `INSERT INTO mail_templates (name, subject, body) VALUES ($1, $2, $3) RETURNING id`,
+				t.Name, t.Subject, t.Body).Scan(&t.ID)
 		} else {
+			_, err = s.db.ExecContext(ctx, `UPDATE mail_templates SET name = $2, subject = $3, body = $4 WHERE id = $1`, t.ID, t.Name, t.Subject, t.Body)
+		}
+		if err != nil {
+			s.logger.Error("Failed to save mail template", zap.Error(err))
+			w.WriteHeader(500)
+			return
+		}
+		s.logger.Info("Saved mail template", zap.Uint32("id", t.ID), zap.String("name", t.Name), zap.Uint32("opID", userID))
+	}
+	templates := make([]MailTemplate, 0)
+	err = s.db.SelectContext(ctx, &templates, `SELECT id, name, subject, body FROM mail_templates ORDER BY id`)
+	if err != nil {
+		s.logger.Error("Failed to get mail templates", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(templates)
+}
 
-			_, err = os.Stat(safePath)
-			if err != nil {
-				if os.IsNotExist(err) {
-					err = os.MkdirAll(safePath, os.ModePerm)
-					if err != nil {
-						s.logger.Error("Error writing screenshot, could not create folder")
-						result = Result{Code: "500"}
-					}
-				} else {
-					s.logger.Error("Error writing screenshot")
-					result = Result{Code: "500"}
-				}
-			}
-			// Create or open the output file
-			outputFile, err := os.Create(verified)
-			if err != nil {
-				result = Result{Code: "500"}
-			}
-			defer outputFile.Close()
-
-			// Encode the image and write it to the file
-			err = jpeg.Encode(outputFile, img, &jpeg.Options{Quality: s.erupeConfig.Screenshots.UploadQuality})
-			if err != nil {
-				s.logger.Error("Error writing screenshot, could not write file", zap.Error(err))
-				result = Result{Code: "500"}
-			}
+func (s *APIServer) CharacterLists(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token  string   `json:"token"`
+		CharID uint32   `json:"charId"`
+		List   string   `json:"list"`
+		Add    []uint32 `json:"add"`
+		Remove []uint32 `json:"remove"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	if !s.ownsCharacter(ctx, userID, reqData.CharID) && !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	for _, change := range []struct {
+		ids    []uint32
+		remove bool
+	}{{reqData.Remove, true}, {reqData.Add, false}} {
+		if len(change.ids) == 0 {
+			continue
+		}
+		err = channelserver.UpdateCharacterList(s.db, reqData.CharID, reqData.List, change.ids, change.remove)
+		if errors.Is(err, channelserver.ErrInvalidCharacterList) {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error()))
+			return
+		} else if err != nil {
+			s.logger.Error("Failed to update character list", zap.Error(err), zap.Uint32("charID", reqData.CharID))
+			w.WriteHeader(500)
+			return
 		}
 	}
-	// Marshal the struct into XML
-	xmlData, err := xml.Marshal(result)
+	entries, err := channelserver.GetCharacterList(s.db, reqData.CharID, reqData.List)
+	if errors.Is(err, channelserver.ErrInvalidCharacterList) {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		s.logger.Error("Failed to get character list", zap.Error(err), zap.Uint32("charID", reqData.CharID))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+func (s *APIServer) Presence(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var reqData struct {
+		Token   string   `json:"token"`
+		CharIDs []uint32 `json:"charIds"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&reqData); err != nil {
+		s.logger.Error("JSON decode error", zap.Error(err))
+		w.WriteHeader(400)
+		return
+	}
+	userID, err := s.userIDFromToken(ctx, reqData.Token)
 	if err != nil {
-		http.Error(w, "Unable to marshal XML", http.StatusInternalServerError)
+		w.WriteHeader(401)
 		return
 	}
-	// Write the XML response with a 200 status code
-	w.WriteHeader(http.StatusOK)
-	w.Write(xmlData)
+	if !s.isOp(ctx, userID) {
+		w.WriteHeader(403)
+		return
+	}
+	var presence []channelserver.Presence
+	if len(reqData.CharIDs) > 0 {
+		presence, err = channelserver.GetPresence(s.db, reqData.CharIDs)
+	} else {
+		presence, err = channelserver.GetOnlinePresence(s.db)
+	}
+	if err != nil {
+		s.logger.Error("Failed to get presence", zap.Error(err))
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(presence)
 }
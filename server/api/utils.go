@@ -3,9 +3,19 @@ package api
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
 	"path/filepath"
 )
 
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 func inTrustedRoot(path string, trustedRoot string) error {
 	for path != "/" {
 		path = filepath.Dir(path)
@@ -16,6 +26,16 @@ func inTrustedRoot(path string, trustedRoot string) error {
 	return errors.New("path is outside of trusted root")
 }
 
+// writeAuthError responds with an authentication error code.
+func writeAuthError(w http.ResponseWriter, code string) {
+	if code == "locked-error" {
+		w.WriteHeader(429)
+	} else {
+		w.WriteHeader(400)
+	}
+	w.Write([]byte(code))
+}
+
 func verifyPath(path string, trustedRoot string) (string, error) {
 
 	c := filepath.Clean(path)
@@ -2,10 +2,13 @@ package deltacomp
 
 import (
 	"bytes"
-	"fmt"
+	"errors"
 	"io"
 )
 
+// ErrInvalidPatch is returned when a diff cannot be applied to the base data.
+var ErrInvalidPatch = errors.New("invalid or misunderstood patch format")
+
 func checkReadUint8(r *bytes.Reader) (uint8, error) {
 	b, err := r.ReadByte()
 	if err != nil {
@@ -46,10 +49,9 @@ func readCount(r *bytes.Reader) (int, error) {
 	return int(count), nil
 }
 
-// ApplyDataDiff applies a delta data diff patch onto given base data.
-func ApplyDataDiff(diff []byte, baseData []byte) []byte {
-	// Make a copy of the base data to return,
-	// (probably just make this modify the given slice in the future).
+// ApplyDataDiff applies a delta data diff patch onto given base data,
+// returning ErrInvalidPatch if the patch is malformed.
+func ApplyDataDiff(diff []byte, baseData []byte) ([]byte, error) {
 	baseCopy := make([]byte, len(baseData))
 	copy(baseCopy, baseData)
 
@@ -58,48 +60,35 @@ func ApplyDataDiff(diff []byte, baseData []byte) []byte {
 	// The very first matchCount is +1 more than it should be, so we start at -1.
 	dataOffset := -1
 	for {
-		// Read the amount of matching bytes.
 		matchCount, err := readCount(patch)
 		if err != nil {
-			// No more data
 			break
 		}
-
 		dataOffset += matchCount
 
-		// Read the amount of differing bytes.
 		differentCount, err := readCount(patch)
 		if err != nil {
-			// No more data
 			break
 		}
 		differentCount--
 
-		// Grow slice if it's required
-		if len(baseCopy) < dataOffset {
-			fmt.Printf("Slice smaller than data offset, growing slice...")
-			baseCopy = append(baseCopy, make([]byte, (dataOffset+differentCount)-len(baseData))...)
-		} else {
-			length := len(baseCopy[dataOffset:])
-			if length < differentCount {
-				length -= differentCount
-				baseCopy = append(baseCopy, make([]byte, length)...)
-			}
+		if dataOffset < 0 || differentCount < 0 {
+			return nil, ErrInvalidPatch
+		}
+		if end := dataOffset + differentCount; end > len(baseCopy) {
+			baseCopy = append(baseCopy, make([]byte, end-len(baseCopy))...)
 		}
 
-		// Apply the patch bytes.
 		for i := 0; i < differentCount; i++ {
 			b, err := checkReadUint8(patch)
 			if err != nil {
-				panic("Invalid or misunderstood patch format!")
+				return nil, ErrInvalidPatch
 			}
-
 			baseCopy[dataOffset+i] = b
 		}
 
 		dataOffset += differentCount - 1
-
 	}
 
-	return baseCopy
+	return baseCopy, nil
 }
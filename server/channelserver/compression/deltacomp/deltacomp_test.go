@@ -102,7 +102,10 @@ func TestDeltaPatch(t *testing.T) {
 			// Apply the patches in order.
 			for i, patch := range patches {
 				fmt.Println("patch index: ", i)
-				data = ApplyDataDiff(patch, data)
+				data, err = ApplyDataDiff(patch, data)
+				if err != nil {
+					t.Fatal(err)
+				}
 			}
 
 			if !bytes.Equal(data, afterData) {
@@ -111,3 +114,11 @@ func TestDeltaPatch(t *testing.T) {
 		})
 	}
 }
+
+func TestDeltaPatchTruncated(t *testing.T) {
+	// Match 4 bytes, then claim 3 differing bytes but only provide 1.
+	_, err := ApplyDataDiff([]byte{5, 4, 0xFF}, make([]byte, 16))
+	if err != ErrInvalidPatch {
+		t.Errorf("got %v, want %v", err, ErrInvalidPatch)
+	}
+}
@@ -31,8 +31,13 @@ func stubEnumerateNoResults(s *Session, ackHandle uint32) {
 }
 
 func doAckEarthSucceed(s *Session, ackHandle uint32, data []*byteframe.ByteFrame) {
+	doAckEarthIDSucceed(s, ackHandle, getEarth(s).ID, data)
+}
+
+// doAckEarthIDSucceed is doAckEarthSucceed for handlers that have already looked up the Earth ID.
+func doAckEarthIDSucceed(s *Session, ackHandle uint32, earthID uint32, data []*byteframe.ByteFrame) {
 	bf := byteframe.NewByteFrame()
-	bf.WriteUint32(uint32(s.server.erupeConfig.EarthID))
+	bf.WriteUint32(earthID)
 	bf.WriteUint32(0)
 	bf.WriteUint32(0)
 	bf.WriteUint32(uint32(len(data)))
@@ -130,7 +135,7 @@ func handleMsgSysLogin(s *Session, p mhfpacket.MHFPacket) {
 
 	if !s.server.erupeConfig.DebugOptions.DisableTokenCheck {
 		var token string
-		err := s.server.db.QueryRow("SELECT token FROM sign_sessions ss INNER JOIN public.users u on ss.user_id = u.id WHERE token=$1 AND ss.id=$2 AND u.id=(SELECT c.user_id FROM characters c WHERE c.id=$3)", pkt.LoginTokenString, pkt.LoginTokenNumber, pkt.CharID0).Scan(&token)
+		err := s.server.db.QueryRow("SELECT token FROM sign_sessions ss INNER JOIN public.users u on ss.user_id = u.id WHERE token=$1 AND ss.id=$2 AND u.id=(SELECT c.user_id FROM characters c WHERE c.id=$3) AND (ss.expires IS NULL OR ss.expires > now())", pkt.LoginTokenString, pkt.LoginTokenNumber, pkt.CharID0).Scan(&token)
 		if err != nil {
 			s.rawConn.Close()
 			s.logger.Warn(fmt.Sprintf("Invalid login token, offending CID: (%d)", pkt.CharID0))
@@ -143,6 +148,13 @@ func handleMsgSysLogin(s *Session, p mhfpacket.MHFPacket) {
 	s.token = pkt.LoginTokenString
 	s.Unlock()
 
+	// Clients with a cached server list or a direct connection can still reach a channel in maintenance
+	if s.server.inMaintenance() && !s.isOp() {
+		s.rawConn.Close()
+		s.logger.Info(fmt.Sprintf("Rejected login during maintenance, CID: (%d)", pkt.CharID0))
+		return
+	}
+
 	bf := byteframe.NewByteFrame()
 	bf.WriteUint32(uint32(TimeAdjusted().Unix())) // Unix timestamp
 
@@ -156,6 +168,15 @@ func handleMsgSysLogin(s *Session, p mhfpacket.MHFPacket) {
 		panic(err)
 	}
 
+	updatePresence(s, true)
+
+	if s.server.erupeConfig.Sign.TokenExpiry > 0 {
+		_, err = s.server.db.Exec("UPDATE sign_sessions SET expires=$1 WHERE token=$2", time.Now().Add(time.Duration(s.server.erupeConfig.Sign.TokenExpiry)*time.Second), s.token)
+	}
+	if err != nil {
+		panic(err)
+	}
+
 	_, err = s.server.db.Exec("UPDATE characters SET last_login=$1 WHERE id=$2", TimeAdjusted().Unix(), s.charID)
 	if err != nil {
 		panic(err)
@@ -178,6 +199,16 @@ func handleMsgSysLogout(s *Session, p mhfpacket.MHFPacket) {
 }
 
 func logoutPlayer(s *Session) {
+	s.logoutOnce.Do(func() {
+		s.logoutErr = logoutSession(s)
+		if s.logoutErr != nil {
+			s.logger.Error("Failed to persist session on logout", zap.Error(s.logoutErr), zap.Uint32("charID", s.charID))
+		}
+	})
+}
+
+// logoutSession removes a session from the server and persists its state.
+func logoutSession(s *Session) error {
 	s.server.Lock()
 	if _, exists := s.server.sessions[s.rawConn]; exists {
 		delete(s.server.sessions, s.rawConn)
@@ -206,12 +237,18 @@ func logoutPlayer(s *Session) {
 
 	_, err := s.server.db.Exec("UPDATE sign_sessions SET server_id=NULL, char_id=NULL WHERE token=$1", s.token)
 	if err != nil {
-		panic(err)
+		return err
+	}
+
+	updatePresence(s, false)
+
+	if s.server.erupeConfig.Sign.TokenExpiry > 0 {
+		s.server.db.Exec("UPDATE sign_sessions SET expires=$1 WHERE token=$2", time.Now().Add(time.Duration(s.server.erupeConfig.Sign.TokenExpiry)*time.Second), s.token)
 	}
 
 	_, err = s.server.db.Exec("UPDATE servers SET current_players=$1 WHERE server_id=$2", len(s.server.sessions), s.server.ID)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	var timePlayed int
@@ -230,12 +267,15 @@ func logoutPlayer(s *Session) {
 		timePlayed = timePlayed % 1800
 	}
 
-	s.server.db.Exec("UPDATE characters SET time_played = $1 WHERE id = $2", timePlayed, s.charID)
+	_, err = s.server.db.Exec("UPDATE characters SET time_played = $1 WHERE id = $2", timePlayed, s.charID)
+	if err != nil {
+		return err
+	}
 
 	s.server.db.Exec(`UPDATE guild_characters SET treasure_hunt=NULL WHERE character_id=$1`, s.charID)
 
 	if s.stage == nil {
-		return
+		return nil
 	}
 
 	s.server.BroadcastMHF(&mhfpacket.MsgSysDeleteUser{
@@ -256,13 +296,13 @@ func logoutPlayer(s *Session) {
 	saveData, err := GetCharacterSaveData(s, s.charID)
 	if err != nil || saveData == nil {
 		s.logger.Error("Failed to get savedata")
-		return
+		return err
 	}
 	saveData.RP += uint16(rpGained)
 	if saveData.RP >= s.server.erupeConfig.GameplayOptions.MaximumRP {
 		saveData.RP = s.server.erupeConfig.GameplayOptions.MaximumRP
 	}
-	saveData.Save(s)
+	return saveData.Save(s)
 }
 
 func handleMsgSysSetStatus(s *Session, p mhfpacket.MHFPacket) {}
@@ -1114,66 +1154,6 @@ func handleMsgMhfUnreserveSrg(s *Session, p mhfpacket.MHFPacket) {
 
 func handleMsgMhfKickExportForce(s *Session, p mhfpacket.MHFPacket) {}
 
-func handleMsgMhfGetEarthStatus(s *Session, p mhfpacket.MHFPacket) {
-	pkt := p.(*mhfpacket.MsgMhfGetEarthStatus)
-	bf := byteframe.NewByteFrame()
-	bf.WriteUint32(uint32(TimeWeekStart().Unix())) // Start
-	bf.WriteUint32(uint32(TimeWeekNext().Unix()))  // End
-	bf.WriteInt32(s.server.erupeConfig.EarthStatus)
-	bf.WriteInt32(s.server.erupeConfig.EarthID)
-	for i, m := range s.server.erupeConfig.EarthMonsters {
-		if _config.ErupeConfig.RealClientMode <= _config.G9 {
-			if i == 3 {
-				break
-			}
-		}
-		if i == 4 {
-			break
-		}
-		bf.WriteInt32(m)
-	}
-	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
-}
-
-func handleMsgMhfRegistSpabiTime(s *Session, p mhfpacket.MHFPacket) {}
-
-func handleMsgMhfGetEarthValue(s *Session, p mhfpacket.MHFPacket) {
-	pkt := p.(*mhfpacket.MsgMhfGetEarthValue)
-	type EarthValues struct {
-		Value []uint32
-	}
-
-	var earthValues []EarthValues
-	switch pkt.ReqType {
-	case 1:
-		earthValues = []EarthValues{
-			{[]uint32{1, 312, 0, 0, 0, 0}},
-			{[]uint32{2, 99, 0, 0, 0, 0}},
-		}
-	case 2:
-		earthValues = []EarthValues{
-			{[]uint32{1, 5771, 0, 0, 0, 0}},
-			{[]uint32{2, 1847, 0, 0, 0, 0}},
-		}
-	case 3:
-		earthValues = []EarthValues{
-			{[]uint32{1001, 36, 0, 0, 0, 0}},
-			{[]uint32{9001, 3, 0, 0, 0, 0}},
-			{[]uint32{9002, 10, 300, 0, 0, 0}},
-		}
-	}
-
-	var data []*byteframe.ByteFrame
-	for _, i := range earthValues {
-		bf := byteframe.NewByteFrame()
-		for _, j := range i.Value {
-			bf.WriteUint32(j)
-		}
-		data = append(data, bf)
-	}
-	doAckEarthSucceed(s, pkt.AckHandle, data)
-}
-
 func handleMsgMhfDebugPostValue(s *Session, p mhfpacket.MHFPacket) {}
 
 func handleMsgMhfGetRandFromTable(s *Session, p mhfpacket.MHFPacket) {
@@ -1193,149 +1173,6 @@ func handleMsgMhfGetSenyuDailyCount(s *Session, p mhfpacket.MHFPacket) {
 	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
 
-type SeibattleTimetable struct {
-	Start time.Time
-	End   time.Time
-}
-
-type SeibattleKeyScore struct {
-	Unk0 uint8
-	Unk1 int32
-}
-
-type SeibattleCareer struct {
-	Unk0 uint16
-	Unk1 uint16
-	Unk2 uint16
-}
-
-type SeibattleOpponent struct {
-	Unk0 int32
-	Unk1 int8
-}
-
-type SeibattleConventionResult struct {
-	Unk0 uint32
-	Unk1 uint16
-	Unk2 uint16
-	Unk3 uint16
-	Unk4 uint16
-}
-
-type SeibattleCharScore struct {
-	Unk0 uint32
-}
-
-type SeibattleCurResult struct {
-	Unk0 uint32
-	Unk1 uint16
-	Unk2 uint16
-	Unk3 uint16
-}
-
-type Seibattle struct {
-	Timetable        []SeibattleTimetable
-	KeyScore         []SeibattleKeyScore
-	Career           []SeibattleCareer
-	Opponent         []SeibattleOpponent
-	ConventionResult []SeibattleConventionResult
-	CharScore        []SeibattleCharScore
-	CurResult        []SeibattleCurResult
-}
-
-func handleMsgMhfGetSeibattle(s *Session, p mhfpacket.MHFPacket) {
-	pkt := p.(*mhfpacket.MsgMhfGetSeibattle)
-	var data []*byteframe.ByteFrame
-	seibattle := Seibattle{
-		Timetable: []SeibattleTimetable{
-			{TimeMidnight(), TimeMidnight().Add(time.Hour * 8)},
-			{TimeMidnight().Add(time.Hour * 8), TimeMidnight().Add(time.Hour * 16)},
-			{TimeMidnight().Add(time.Hour * 16), TimeMidnight().Add(time.Hour * 24)},
-		},
-		KeyScore: []SeibattleKeyScore{
-			{0, 0},
-		},
-		Career: []SeibattleCareer{
-			{0, 0, 0},
-		},
-		Opponent: []SeibattleOpponent{
-			{1, 1},
-		},
-		ConventionResult: []SeibattleConventionResult{
-			{0, 0, 0, 0, 0},
-		},
-		CharScore: []SeibattleCharScore{
-			{0},
-		},
-		CurResult: []SeibattleCurResult{
-			{0, 0, 0, 0},
-		},
-	}
-
-	switch pkt.Type {
-	case 1:
-		for _, timetable := range seibattle.Timetable {
-			bf := byteframe.NewByteFrame()
-			bf.WriteUint32(uint32(timetable.Start.Unix()))
-			bf.WriteUint32(uint32(timetable.End.Unix()))
-			data = append(data, bf)
-		}
-	case 3: // Key score?
-		for _, keyScore := range seibattle.KeyScore {
-			bf := byteframe.NewByteFrame()
-			bf.WriteUint8(keyScore.Unk0)
-			bf.WriteInt32(keyScore.Unk1)
-			data = append(data, bf)
-		}
-	case 4: // Career?
-		for _, career := range seibattle.Career {
-			bf := byteframe.NewByteFrame()
-			bf.WriteUint16(career.Unk0)
-			bf.WriteUint16(career.Unk1)
-			bf.WriteUint16(career.Unk2)
-			data = append(data, bf)
-		}
-	case 5: // Opponent?
-		for _, opponent := range seibattle.Opponent {
-			bf := byteframe.NewByteFrame()
-			bf.WriteInt32(opponent.Unk0)
-			bf.WriteInt8(opponent.Unk1)
-			data = append(data, bf)
-		}
-	case 6: // Convention result?
-		for _, conventionResult := range seibattle.ConventionResult {
-			bf := byteframe.NewByteFrame()
-			bf.WriteUint32(conventionResult.Unk0)
-			bf.WriteUint16(conventionResult.Unk1)
-			bf.WriteUint16(conventionResult.Unk2)
-			bf.WriteUint16(conventionResult.Unk3)
-			bf.WriteUint16(conventionResult.Unk4)
-			data = append(data, bf)
-		}
-	case 7: // Char score?
-		for _, charScore := range seibattle.CharScore {
-			bf := byteframe.NewByteFrame()
-			bf.WriteUint32(charScore.Unk0)
-			data = append(data, bf)
-		}
-	case 8: // Cur result?
-		for _, curResult := range seibattle.CurResult {
-			bf := byteframe.NewByteFrame()
-			bf.WriteUint32(curResult.Unk0)
-			bf.WriteUint16(curResult.Unk1)
-			bf.WriteUint16(curResult.Unk2)
-			bf.WriteUint16(curResult.Unk3)
-			data = append(data, bf)
-		}
-	}
-	doAckEarthSucceed(s, pkt.AckHandle, data)
-}
-
-func handleMsgMhfPostSeibattle(s *Session, p mhfpacket.MHFPacket) {
-	pkt := p.(*mhfpacket.MsgMhfPostSeibattle)
-	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
-}
-
 func handleMsgMhfGetDailyMissionMaster(s *Session, p mhfpacket.MHFPacket) {}
 
 func handleMsgMhfGetDailyMissionPersonal(s *Session, p mhfpacket.MHFPacket) {}
@@ -7,6 +7,8 @@ import (
 	_config "erupe-ce/config"
 	"erupe-ce/network/mhfpacket"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 type CampaignEvent struct {
@@ -47,12 +49,75 @@ type CampaignLink struct {
 	CampaignID uint32
 }
 
+type CampaignReward struct {
+	ID       uint32 `db:"id"`
+	ItemType uint16 `db:"item_type"`
+	ItemID   uint16 `db:"item_id"`
+	Quantity uint16 `db:"quantity"`
+	Claimed  uint32 `db:"claimed"`
+}
+
+// getCampaigns returns the campaigns running now along with their categories.
+func getCampaigns(s *Session) ([]CampaignEvent, []CampaignCategory) {
+	var events []CampaignEvent
+	var categories []CampaignCategory
+	now := TimeAdjusted()
+	rows, err := s.server.db.Query(`SELECT id, min_hr, max_hr, min_gr, max_gr, start_time, end_time, title, reward,
+		description, notes, link, prefix FROM campaigns WHERE start_time <= $1 AND end_time > $1 ORDER BY id`, now)
+	if err != nil {
+		s.logger.Error("Failed to get campaigns", zap.Error(err))
+		return events, categories
+	}
+	for rows.Next() {
+		// SR is not mapped, so campaigns are never gated on it
+		event := CampaignEvent{MinSR: -1, MaxSR: -1}
+		err = rows.Scan(&event.ID, &event.MinHR, &event.MaxHR, &event.MinGR, &event.MaxGR, &event.Start, &event.End,
+			&event.String0, &event.String1, &event.String2, &event.String3, &event.Link, &event.Prefix)
+		if err != nil {
+			s.logger.Error("Failed to scan campaign", zap.Error(err))
+			continue
+		}
+		s.server.db.Select(&event.Categories, `SELECT category_id FROM campaign_category_links WHERE campaign_id = $1 ORDER BY category_id`, event.ID)
+		events = append(events, event)
+	}
+	rows.Close()
+
+	rows, err = s.server.db.Query(`SELECT id, type, title, description FROM campaign_categories ORDER BY id`)
+	if err != nil {
+		s.logger.Error("Failed to get campaign categories", zap.Error(err))
+		return events, categories
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var category CampaignCategory
+		if err = rows.Scan(&category.ID, &category.Type, &category.Title, &category.Description); err == nil {
+			categories = append(categories, category)
+		}
+	}
+	return events, categories
+}
+
+// campaignAvailable reports whether a campaign is running and the character meets its rank gates.
+func campaignAvailable(s *Session, campaignID uint32) bool {
+	var gate rankGate
+	err := s.server.db.QueryRow(`SELECT min_hr, max_hr, min_gr, max_gr FROM campaigns WHERE id = $1 AND start_time <= $2 AND end_time > $2`,
+		campaignID, TimeAdjusted()).Scan(&gate.MinHR, &gate.MaxHR, &gate.MinGR, &gate.MaxGR)
+	if err != nil {
+		return false
+	}
+	hr, gr, err := getCharacterRanks(s.server.db, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to get character ranks", zap.Error(err))
+		return false
+	}
+	return gate.allows(hr, gr)
+}
+
 func handleMsgMhfEnumerateCampaign(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateCampaign)
 	bf := byteframe.NewByteFrame()
 
-	events := []CampaignEvent{}
-	categories := []CampaignCategory{}
+	events, categories := getCampaigns(s)
 	var campaignLinks []CampaignLink
 
 	if len(events) > 255 {
@@ -134,43 +199,73 @@ func handleMsgMhfEnumerateCampaign(s *Session, p mhfpacket.MHFPacket) {
 
 func handleMsgMhfStateCampaign(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfStateCampaign)
+	var entered uint16
+	s.server.db.QueryRow(`SELECT COUNT(*) FROM campaign_entries WHERE campaign_id = $1 AND character_id = $2`, pkt.CampaignID, s.charID).Scan(&entered)
 	bf := byteframe.NewByteFrame()
 	bf.WriteUint16(1)
-	bf.WriteUint16(0)
+	bf.WriteUint16(entered) // Entered?
 	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
 
 func handleMsgMhfApplyCampaign(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfApplyCampaign)
 	bf := byteframe.NewByteFrame()
+	if !campaignAvailable(s, pkt.CampaignID) {
+		bf.WriteUint32(0)
+		doAckSimpleFail(s, pkt.AckHandle, bf.Data())
+		return
+	}
+	_, err := s.server.db.Exec(`INSERT INTO campaign_entries (campaign_id, character_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, pkt.CampaignID, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to enter campaign", zap.Error(err))
+	}
 	bf.WriteUint32(1)
 	doAckSimpleSucceed(s, pkt.AckHandle, bf.Data())
 }
 
 func handleMsgMhfEnumerateItem(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateItem)
-	items := []struct {
-		Unk0 uint32
-		Unk1 uint16
-		Unk2 uint16
-		Unk3 uint16
-		Unk4 uint32
-		Unk5 uint32
-	}{}
+	var items []CampaignReward
+	err := s.server.db.Select(&items, `SELECT r.id, r.item_type, r.item_id, r.quantity,
+		(SELECT COUNT(*) FROM campaign_claims c WHERE c.reward_id = r.id AND c.character_id = $2) AS claimed
+		FROM campaign_rewards r WHERE r.campaign_id = $1 ORDER BY r.id`, pkt.CampaignID, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to get campaign rewards", zap.Error(err))
+	}
+	// The layout of a reward is unconfirmed. Its fields are assumed to be the reward ID, item type,
+	// item ID, quantity and the number of times it was claimed.
 	bf := byteframe.NewByteFrame()
 	bf.WriteUint16(uint16(len(items)))
 	for _, item := range items {
-		bf.WriteUint32(item.Unk0)
-		bf.WriteUint16(item.Unk1)
-		bf.WriteUint16(item.Unk2)
-		bf.WriteUint16(item.Unk3)
-		bf.WriteUint32(item.Unk4)
-		bf.WriteUint32(item.Unk5)
+		bf.WriteUint32(item.ID)
+		bf.WriteUint16(item.ItemType)
+		bf.WriteUint16(item.ItemID)
+		bf.WriteUint16(item.Quantity)
+		bf.WriteUint32(item.Claimed)
+		bf.WriteUint32(0)
 	}
 	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
 
 func handleMsgMhfAcquireItem(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfAcquireItem)
+	// Unk1 is assumed to hold the IDs of the rewards sent by handleMsgMhfEnumerateItem.
+	for _, rewardID := range pkt.Unk1 {
+		// Rewards can only be claimed once, from campaigns the character has entered.
+		_, err := s.server.db.Exec(`WITH claim AS (
+				INSERT INTO campaign_claims (reward_id, character_id)
+				SELECT r.id, $2 FROM campaign_rewards r JOIN campaign_entries e ON e.campaign_id = r.campaign_id AND e.character_id = $2
+				WHERE r.id = $1 ON CONFLICT DO NOTHING RETURNING reward_id
+			), dist AS (
+				INSERT INTO distribution (character_id, type, event_name, description, times_acceptable)
+				SELECT $2, 1, 'Campaign', '~C05Campaign reward.', 1 FROM claim
+				RETURNING id
+			)
+			INSERT INTO distribution_items (distribution_id, item_type, item_id, quantity)
+			SELECT d.id, r.item_type, r.item_id, r.quantity FROM dist d, campaign_rewards r WHERE r.id = $1`, rewardID, s.charID)
+		if err != nil {
+			s.logger.Error("Failed to claim campaign reward", zap.Error(err), zap.Uint32("rewardID", rewardID))
+		}
+	}
 	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 }
@@ -2,6 +2,7 @@ package channelserver
 
 import (
 	"crypto/rand"
+	"database/sql"
 	"encoding/hex"
 	"erupe-ce/common/byteframe"
 	"erupe-ce/common/mhfcid"
@@ -14,6 +15,7 @@ import (
 	"fmt"
 	"golang.org/x/exp/slices"
 	"math"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -131,6 +133,7 @@ func parseChatCommand(s *Session, command string) {
                  				ON CONFLICT (user_id) DO UPDATE SET expires=$2`, uid, expiry)
 							sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.ban.success, uname)+fmt.Sprintf(s.server.i18n.commands.ban.length, expiry.Format(time.DateTime)))
 						}
+						s.server.db.Exec(`DELETE FROM sign_sessions WHERE user_id=$1`, uid)
 						s.server.DisconnectUser(uid)
 					} else {
 						sendServerChatMessage(s, s.server.i18n.commands.ban.noUser)
@@ -144,6 +147,155 @@ func parseChatCommand(s *Session, command string) {
 		} else {
 			sendServerChatMessage(s, s.server.i18n.commands.noOp)
 		}
+	case commands["Rollback"].Prefix:
+		if s.isOp() {
+			if len(args) > 1 {
+				cid := mhfcid.ConvertCID(args[1])
+				if cid == 0 {
+					sendServerChatMessage(s, s.server.i18n.commands.ban.invalid)
+					return
+				}
+				if len(args) > 2 {
+					historyID, err := strconv.ParseUint(args[2], 10, 32)
+					if err != nil {
+						sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.rollback.error, commands["Rollback"].Prefix))
+						return
+					}
+					online, err := CharacterOnline(s.server.db, cid)
+					if err != nil || online {
+						sendServerChatMessage(s, s.server.i18n.commands.rollback.online)
+						return
+					}
+					err = RestoreSaveHistory(s.server.db, cid, uint32(historyID))
+					switch err {
+					case nil:
+						s.logger.Info("Restored savedata", zap.Uint32("charID", cid), zap.Uint64("historyID", historyID))
+						sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.rollback.success, historyID))
+					case sql.ErrNoRows:
+						sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.rollback.notFound, historyID))
+					case ErrSaveModeMismatch:
+						sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.rollback.mode, historyID))
+					default:
+						s.logger.Error("Failed to restore savedata", zap.Error(err), zap.Uint32("charID", cid), zap.Uint64("historyID", historyID))
+						sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.rollback.failed, historyID))
+					}
+				} else {
+					entries, err := GetSaveHistory(s.server.db, cid)
+					if err != nil || len(entries) == 0 {
+						sendServerChatMessage(s, s.server.i18n.commands.rollback.none)
+						return
+					}
+					for _, entry := range entries {
+						sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.rollback.entry, entry.ID, entry.CreatedAt.Format(time.DateTime), entry.HR, entry.GR, entry.Size))
+					}
+				}
+			} else {
+				sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.rollback.error, commands["Rollback"].Prefix))
+			}
+		} else {
+			sendServerChatMessage(s, s.server.i18n.commands.noOp)
+		}
+	case commands["Gift"].Prefix:
+		if s.isOp() {
+			if len(args) < 5 {
+				sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.gift.error, commands["Gift"].Prefix))
+				return
+			}
+			var d NewDistribution
+			var item NewDistributionItem
+			var id uint64
+			var lo, hi int16
+			_, err := fmt.Sscanf(strings.Join(args[2:5], " "), "%d %d %d", &item.ItemType, &item.ItemID, &item.Quantity)
+			if err != nil {
+				sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.gift.error, commands["Gift"].Prefix))
+				return
+			}
+			d.Items = append(d.Items, item)
+			switch {
+			case args[1] == DistributionTargetOnline:
+				d.Target = DistributionTargetOnline
+			case strings.HasPrefix(args[1], "guild:"):
+				d.Target = DistributionTargetGuild
+				id, err = strconv.ParseUint(args[1][6:], 10, 32)
+				d.TargetID = uint32(id)
+			case strings.HasPrefix(args[1], "hr:"):
+				d.Target = DistributionTargetRank
+				_, err = fmt.Sscanf(args[1][3:], "%d-%d", &lo, &hi)
+				d.MinHR, d.MaxHR = &lo, &hi
+			case strings.HasPrefix(args[1], "gr:"):
+				d.Target = DistributionTargetRank
+				_, err = fmt.Sscanf(args[1][3:], "%d-%d", &lo, &hi)
+				d.MinGR, d.MaxGR = &lo, &hi
+			default:
+				d.Target = DistributionTargetCharacter
+				d.TargetID = mhfcid.ConvertCID(args[1])
+			}
+			if err != nil {
+				sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.gift.error, commands["Gift"].Prefix))
+				return
+			}
+			recipients, err := CreateDistribution(s.server.db, d)
+			if err != nil {
+				sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.gift.invalid, err))
+				return
+			}
+			s.logger.Info("Created distribution", zap.String("target", args[1]), zap.Int("recipients", recipients), zap.Uint32("charID", s.charID))
+			sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.gift.success, recipients))
+		} else {
+			sendServerChatMessage(s, s.server.i18n.commands.noOp)
+		}
+	case commands["Time"].Prefix:
+		if s.isOp() {
+			if len(args) > 1 {
+				if args[1] == "reset" {
+					ServerClock.SetOffset(time.Duration(s.server.erupeConfig.TimeOffset) * time.Second)
+				} else {
+					var days int
+					var unit string
+					duration, err := time.ParseDuration(args[1])
+					if n, _ := fmt.Sscanf(args[1], "%d%s", &days, &unit); n == 2 && unit == "d" {
+						duration, err = time.Duration(days)*24*time.Hour, nil
+					}
+					if err != nil {
+						sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.time.error, commands["Time"].Prefix))
+						return
+					}
+					ServerClock.Travel(duration)
+				}
+				s.logger.Info("Shifted server clock", zap.Duration("offset", ServerClock.Offset()), zap.Uint32("charID", s.charID))
+			}
+			sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.time.current, TimeAdjusted().Format(time.DateTime), ServerClock.Offset()))
+		} else {
+			sendServerChatMessage(s, s.server.i18n.commands.noOp)
+		}
+	case commands["QuestPack"].Prefix:
+		if s.isOp() {
+			if len(args) < 2 {
+				pack := s.server.binFiles.Pack()
+				if pack == nil {
+					sendServerChatMessage(s, s.server.i18n.commands.questPack.none)
+				} else {
+					sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.questPack.current, pack))
+				}
+				return
+			}
+			if args[1] == "off" {
+				s.server.SwapQuestPack(nil)
+				s.logger.Info("Unloaded quest pack")
+				sendServerChatMessage(s, s.server.i18n.commands.questPack.unloaded)
+				return
+			}
+			pack, err := LoadQuestPack(filepath.Join(s.server.erupeConfig.BinPath, filepath.Clean("/"+args[1])))
+			if err != nil {
+				sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.questPack.error, err))
+				return
+			}
+			s.server.SwapQuestPack(pack)
+			s.logger.Info("Loaded quest pack", zap.String("pack", pack.String()))
+			sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.questPack.loaded, pack))
+		} else {
+			sendServerChatMessage(s, s.server.i18n.commands.noOp)
+		}
 	case commands["Timer"].Prefix:
 		if commands["Timer"].Enabled || s.isOp() {
 			var state bool
@@ -414,6 +566,33 @@ func parseChatCommand(s *Session, command string) {
 		} else {
 			sendDisabledCommandMessage(s, commands["Playtime"])
 		}
+	case commands["Friends"].Prefix:
+		if commands["Friends"].Enabled || s.isOp() {
+			friends, err := GetCharacterList(s.server.db, s.charID, CharacterListFriends)
+			if err != nil {
+				s.logger.Error("Failed to get friends", zap.Error(err))
+			}
+			var online int
+			for _, friend := range friends {
+				if !friend.Online || friend.Channel == nil {
+					continue
+				}
+				online++
+				var world, inQuest string
+				if friend.WorldName != nil {
+					world = *friend.WorldName
+				}
+				if friend.InQuest {
+					inQuest = s.server.i18n.commands.friends.inQuest
+				}
+				sendServerChatMessage(s, fmt.Sprintf(s.server.i18n.commands.friends.entry, friend.Name, world, *friend.Channel, inQuest))
+			}
+			if online == 0 {
+				sendServerChatMessage(s, s.server.i18n.commands.friends.none)
+			}
+		} else {
+			sendDisabledCommandMessage(s, commands["Friends"])
+		}
 	case commands["Help"].Prefix:
 		if commands["Help"].Enabled || s.isOp() {
 			for _, command := range commands {
@@ -536,6 +715,9 @@ func handleMsgSysCastBinary(s *Session, p mhfpacket.MHFPacket) {
 		}
 	case BroadcastTypeTargeted:
 		for _, targetID := range (*msgBinTargeted).TargetCharIDs {
+			if pkt.MessageType == BinaryMessageTypeChat && IsBlocked(s.server.db, targetID, s.charID) {
+				continue
+			}
 			char := s.server.FindSessionByCharID(targetID)
 
 			if char != nil {
@@ -1,15 +1,21 @@
 package channelserver
 
 import (
+	"database/sql"
 	"encoding/binary"
 	"errors"
 	"erupe-ce/common/bfutil"
 	"erupe-ce/common/stringsupport"
 	_config "erupe-ce/config"
 
+	"strings"
+	"time"
+
 	"erupe-ce/network/mhfpacket"
 	"erupe-ce/server/channelserver/compression/nullcomp"
+	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
+	"golang.org/x/text/encoding/japanese"
 )
 
 type SavePointer int
@@ -58,8 +64,12 @@ type CharacterSaveData struct {
 }
 
 func getPointers() map[SavePointer]int {
+	return getPointersForMode(_config.ErupeConfig.RealClientMode)
+}
+
+func getPointersForMode(mode _config.Mode) map[SavePointer]int {
 	pointers := map[SavePointer]int{pGender: 81, lBookshelfData: 5576}
-	switch _config.ErupeConfig.RealClientMode {
+	switch mode {
 	case _config.ZZ:
 		pointers[pPlaytime] = 128356
 		pointers[pWeaponID] = 128522
@@ -115,25 +125,31 @@ func getPointers() map[SavePointer]int {
 		pointers[pGardenData] = 26424
 		pointers[pRP] = 26614
 	}
-	if _config.ErupeConfig.RealClientMode == _config.G5 {
+	if mode == _config.G5 {
 		pointers[lBookshelfData] = 5548
-	} else if _config.ErupeConfig.RealClientMode <= _config.GG {
+	} else if mode <= _config.GG {
 		pointers[lBookshelfData] = 4520
 	}
 	return pointers
 }
 
 func GetCharacterSaveData(s *Session, charID uint32) (*CharacterSaveData, error) {
-	result, err := s.server.db.Query("SELECT id, savedata, is_new_character, name FROM characters WHERE id = $1", charID)
+	saveData, err := loadCharacterSaveData(s.server.db, charID)
 	if err != nil {
 		s.logger.Error("Failed to get savedata", zap.Error(err), zap.Uint32("charID", charID))
 		return nil, err
 	}
+	return saveData, nil
+}
+
+func loadCharacterSaveData(db *sqlx.DB, charID uint32) (*CharacterSaveData, error) {
+	result, err := db.Query("SELECT id, savedata, is_new_character, name FROM characters WHERE id = $1", charID)
+	if err != nil {
+		return nil, err
+	}
 	defer result.Close()
 	if !result.Next() {
-		err = errors.New("no savedata found")
-		s.logger.Error("No savedata found", zap.Uint32("charID", charID))
-		return nil, err
+		return nil, sql.ErrNoRows
 	}
 
 	saveData := &CharacterSaveData{
@@ -141,7 +157,6 @@ func GetCharacterSaveData(s *Session, charID uint32) (*CharacterSaveData, error)
 	}
 	err = result.Scan(&saveData.CharID, &saveData.compSave, &saveData.IsNewCharacter, &saveData.Name)
 	if err != nil {
-		s.logger.Error("Failed to scan savedata", zap.Error(err), zap.Uint32("charID", charID))
 		return nil, err
 	}
 
@@ -151,7 +166,6 @@ func GetCharacterSaveData(s *Session, charID uint32) (*CharacterSaveData, error)
 
 	err = saveData.Decompress()
 	if err != nil {
-		s.logger.Error("Failed to decompress savedata", zap.Error(err))
 		return nil, err
 	}
 
@@ -160,7 +174,39 @@ func GetCharacterSaveData(s *Session, charID uint32) (*CharacterSaveData, error)
 	return saveData, nil
 }
 
-func (save *CharacterSaveData) Save(s *Session) {
+// ErrInvalidName is returned when a name cannot be stored in savedata.
+var ErrInvalidName = errors.New("invalid character name")
+
+// RenameCharacter changes the name of an offline character in both its savedata and the characters table.
+func RenameCharacter(db *sqlx.DB, charID uint32, name string) error {
+	sjisName, err := japanese.ShiftJIS.NewEncoder().String(name)
+	if err != nil || len(sjisName) == 0 || len(sjisName) > 11 || strings.ContainsRune(name, 0) {
+		return ErrInvalidName
+	}
+	saveData, err := loadCharacterSaveData(db, charID)
+	if err != nil {
+		return err
+	}
+	if saveData.decompSave != nil {
+		if len(saveData.decompSave) < 100 {
+			return ErrInvalidSave
+		}
+		copy(saveData.decompSave[88:100], make([]byte, 12))
+		copy(saveData.decompSave[88:100], sjisName)
+		if _config.ErupeConfig.RealClientMode >= _config.G1 {
+			err = saveData.Compress()
+			if err != nil {
+				return err
+			}
+		} else {
+			saveData.compSave = saveData.decompSave
+		}
+	}
+	_, err = db.Exec(`UPDATE characters SET name=$1, savedata=$2 WHERE id=$3`, name, saveData.compSave, charID)
+	return err
+}
+
+func (save *CharacterSaveData) Save(s *Session) error {
 	if !s.kqfOverride {
 		s.kqf = save.KQF
 	} else {
@@ -173,21 +219,121 @@ func (save *CharacterSaveData) Save(s *Session) {
 		err := save.Compress()
 		if err != nil {
 			s.logger.Error("Failed to compress savedata", zap.Error(err))
-			return
+			return err
 		}
 	} else {
 		// Saves were not compressed
 		save.compSave = save.decompSave
 	}
 
-	_, err := s.server.db.Exec(`UPDATE characters SET savedata=$1, is_new_character=false, hr=$2, gr=$3, is_female=$4, weapon_type=$5, weapon_id=$6 WHERE id=$7
-	`, save.compSave, save.HR, save.GR, save.Gender, save.WeaponType, save.WeaponID, save.CharID)
+	_, err := s.server.db.Exec(`UPDATE characters SET savedata=$1, is_new_character=false, hr=$2, gr=$3, is_female=$4, weapon_type=$5, weapon_id=$6, save_mode=$7 WHERE id=$8
+	`, save.compSave, save.HR, save.GR, save.Gender, save.WeaponType, save.WeaponID, _config.ErupeConfig.RealClientMode.String(), save.CharID)
 	if err != nil {
 		s.logger.Error("Failed to update savedata", zap.Error(err), zap.Uint32("charID", save.CharID))
+		return err
 	}
+	save.addHistory(s)
 
-	s.server.db.Exec(`UPDATE user_binary SET house_tier=$1, house_data=$2, bookshelf=$3, gallery=$4, tore=$5, garden=$6 WHERE id=$7
+	_, err = s.server.db.Exec(`UPDATE user_binary SET house_tier=$1, house_data=$2, bookshelf=$3, gallery=$4, tore=$5, garden=$6 WHERE id=$7
 	`, save.HouseTier, save.HouseData, save.BookshelfData, save.GalleryData, save.ToreData, save.GardenData, s.charID)
+	return err
+}
+
+// addHistory keeps a copy of the save for rollback, pruning the oldest copies beyond the configured length.
+func (save *CharacterSaveData) addHistory(s *Session) {
+	opts := s.server.erupeConfig.SaveHistory
+	if !opts.Enabled || opts.Length <= 0 {
+		return
+	}
+	var recent int
+	s.server.db.QueryRow(`SELECT count(*) FROM savedata_history WHERE character_id=$1 AND created_at > $2`,
+		save.CharID, time.Now().Add(-time.Duration(opts.Interval)*time.Second)).Scan(&recent)
+	if recent > 0 {
+		return
+	}
+	_, err := s.server.db.Exec(`INSERT INTO savedata_history (character_id, savedata, size, hr, gr, save_mode) VALUES ($1, $2, $3, $4, $5, $6)`,
+		save.CharID, save.compSave, len(save.compSave), save.HR, save.GR, _config.ErupeConfig.RealClientMode.String())
+	if err != nil {
+		s.logger.Error("Failed to add savedata history", zap.Error(err), zap.Uint32("charID", save.CharID))
+		return
+	}
+	pruneSaveHistory(s.server.db, save.CharID)
+}
+
+// pruneSaveHistory removes the oldest copies of a character's save beyond the configured length.
+func pruneSaveHistory(db sqlx.Execer, charID uint32) error {
+	length := _config.ErupeConfig.SaveHistory.Length
+	if length <= 0 {
+		return nil
+	}
+	_, err := db.Exec(`DELETE FROM savedata_history WHERE character_id=$1 AND id NOT IN (
+		SELECT id FROM savedata_history WHERE character_id=$1 ORDER BY created_at DESC LIMIT $2)`, charID, length)
+	return err
+}
+
+// archiveSave copies a character's current save into history before it is replaced.
+// Callers prune the history once the replacement is written.
+func archiveSave(tx *sqlx.Tx, charID uint32) error {
+	_, err := tx.Exec(`INSERT INTO savedata_history (character_id, savedata, size, hr, gr, save_mode)
+		SELECT id, savedata, length(savedata), COALESCE(hr, 0), COALESCE(gr, 0), save_mode FROM characters WHERE id=$1 AND savedata IS NOT NULL`, charID)
+	return err
+}
+
+// SaveHistoryEntry describes a previous save kept for rollback.
+type SaveHistoryEntry struct {
+	ID        uint32    `db:"id"`
+	CharID    uint32    `db:"character_id"`
+	Size      int       `db:"size"`
+	HR        uint16    `db:"hr"`
+	GR        uint16    `db:"gr"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// GetSaveHistory returns the previous saves of a character, newest first.
+func GetSaveHistory(db *sqlx.DB, charID uint32) ([]SaveHistoryEntry, error) {
+	entries := make([]SaveHistoryEntry, 0)
+	err := db.Select(&entries, `SELECT id, character_id, size, hr, gr, created_at FROM savedata_history WHERE character_id=$1 ORDER BY created_at DESC`, charID)
+	return entries, err
+}
+
+// ErrSaveModeMismatch is returned when restoring a save stored in the layout of another client mode.
+var ErrSaveModeMismatch = errors.New("save is in the layout of another client mode")
+
+// CharacterOnline reports whether a character is signed in to any channel of any server process.
+func CharacterOnline(db *sqlx.DB, charID uint32) (bool, error) {
+	var online int
+	err := db.QueryRow(`SELECT count(*) FROM sign_sessions WHERE char_id = $1`, charID).Scan(&online)
+	return online > 0, err
+}
+
+// RestoreSaveHistory replaces the save of an offline character with a previous save,
+// keeping the replaced save in history so the rollback can be undone.
+func RestoreSaveHistory(db *sqlx.DB, charID uint32, historyID uint32) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var saveMode sql.NullString
+	err = tx.QueryRow(`SELECT save_mode FROM savedata_history WHERE id=$1 AND character_id=$2`, historyID, charID).Scan(&saveMode)
+	if err != nil {
+		return err
+	}
+	// Saves from before a migration are in the layout of another client mode
+	if saveMode.Valid && saveMode.String != _config.ErupeConfig.RealClientMode.String() {
+		return ErrSaveModeMismatch
+	}
+	if err = archiveSave(tx, charID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE characters c SET savedata=h.savedata, hr=h.hr, gr=h.gr, save_mode=h.save_mode FROM savedata_history h WHERE h.id=$1 AND c.id=$2`, historyID, charID)
+	if err != nil {
+		return err
+	}
+	if err = pruneSaveHistory(tx, charID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 func (save *CharacterSaveData) Compress() error {
@@ -254,6 +400,27 @@ func (save *CharacterSaveData) updateStructWithSaveData() {
 	return
 }
 
+// rankGate holds the inclusive HR and GR ranges of a campaign or distribution, where a negative
+// bound is unset. SR is not gated, as a character's SR is not mapped.
+type rankGate struct {
+	MinHR, MaxHR int16
+	MinGR, MaxGR int16
+}
+
+// allows reports whether the ranks fall within every range of the gate.
+func (g rankGate) allows(hr, gr int16) bool {
+	inRange := func(value, min, max int16) bool {
+		return (min < 0 || value >= min) && (max < 0 || value <= max)
+	}
+	return inRange(hr, g.MinHR, g.MaxHR) && inRange(gr, g.MinGR, g.MaxGR)
+}
+
+// getCharacterRanks returns the HR and GR of a character.
+func getCharacterRanks(db *sqlx.DB, charID uint32) (hr, gr int16, err error) {
+	err = db.QueryRow(`SELECT COALESCE(hr, 0), COALESCE(gr, 0) FROM characters WHERE id = $1`, charID).Scan(&hr, &gr)
+	return
+}
+
 func handleMsgMhfSexChanger(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfSexChanger)
 	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
@@ -58,55 +58,32 @@ func handleMsgSysEnumerateClient(s *Session, p mhfpacket.MHFPacket) {
 func handleMsgMhfListMember(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfListMember)
 
-	var csv string
-	var count uint32
 	resp := byteframe.NewByteFrame()
-	resp.WriteUint32(0) // Blacklist count
-	err := s.server.db.QueryRow("SELECT blocked FROM characters WHERE id=$1", s.charID).Scan(&csv)
-	if err == nil {
-		cids := stringsupport.CSVElems(csv)
-		for _, cid := range cids {
-			var name string
-			err = s.server.db.QueryRow("SELECT name FROM characters WHERE id=$1", cid).Scan(&name)
-			if err != nil {
-				continue
-			}
-			count++
-			resp.WriteUint32(uint32(cid))
-			resp.WriteUint32(16)
-			resp.WriteBytes(stringsupport.PaddedString(name, 16, true))
-		}
+	blocked, err := GetCharacterList(s.server.db, s.charID, CharacterListBlocked)
+	if err != nil {
+		s.logger.Error("Failed to get blacklist", zap.Error(err))
+	}
+	resp.WriteUint32(uint32(len(blocked)))
+	for _, entry := range blocked {
+		resp.WriteUint32(entry.CharID)
+		resp.WriteUint32(16)
+		resp.WriteBytes(stringsupport.PaddedString(entry.Name, 16, true))
 	}
-	resp.Seek(0, 0)
-	resp.WriteUint32(count)
 	doAckBufSucceed(s, pkt.AckHandle, resp.Data())
 }
 
 func handleMsgMhfOprMember(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfOprMember)
-	var csv string
-	for _, cid := range pkt.CharIDs {
-		if pkt.Blacklist {
-			err := s.server.db.QueryRow("SELECT blocked FROM characters WHERE id=$1", s.charID).Scan(&csv)
-			if err == nil {
-				if pkt.Operation {
-					csv = stringsupport.CSVRemove(csv, int(cid))
-				} else {
-					csv = stringsupport.CSVAdd(csv, int(cid))
-				}
-				s.server.db.Exec("UPDATE characters SET blocked=$1 WHERE id=$2", csv, s.charID)
-			}
-		} else { // Friendlist
-			err := s.server.db.QueryRow("SELECT friends FROM characters WHERE id=$1", s.charID).Scan(&csv)
-			if err == nil {
-				if pkt.Operation {
-					csv = stringsupport.CSVRemove(csv, int(cid))
-				} else {
-					csv = stringsupport.CSVAdd(csv, int(cid))
-				}
-				s.server.db.Exec("UPDATE characters SET friends=$1 WHERE id=$2", csv, s.charID)
-			}
-		}
+	list := CharacterListFriends
+	if pkt.Blacklist {
+		list = CharacterListBlocked
+	}
+	// A rejected change leaves the whole list unchanged, so the client is told it failed
+	err := UpdateCharacterList(s.server.db, s.charID, list, pkt.CharIDs, pkt.Operation)
+	if err != nil {
+		s.logger.Error("Failed to update character list", zap.Error(err), zap.String("list", list))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
 	}
 	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 }
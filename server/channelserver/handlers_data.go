@@ -3,7 +3,6 @@ package channelserver
 import (
 	"erupe-ce/common/mhfmon"
 	"erupe-ce/common/stringsupport"
-	_config "erupe-ce/config"
 	"fmt"
 	"io"
 	"os"
@@ -24,26 +23,38 @@ func handleMsgMhfSavedata(s *Session, p mhfpacket.MHFPacket) {
 		s.logger.Error("failed to retrieve character save data from db", zap.Error(err), zap.Uint32("charID", s.charID))
 		return
 	}
+	storedLength := len(characterSaveData.decompSave)
 	// Var to hold the decompressed savedata for updating the launcher response fields.
+	if pkt.SaveType == 1 && s.saveQuarantined {
+		// The client's save no longer matches the stored save the diff would be applied to
+		s.logger.Warn("Refusing diff save until a full save replaces the quarantined save", zap.Uint32("charID", s.charID))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
 	if pkt.SaveType == 1 {
 		// Diff-based update.
 		// diffs themselves are also potentially compressed
 		diff, err := nullcomp.Decompress(pkt.RawDataPayload)
 		if err != nil {
-			s.logger.Error("Failed to decompress diff", zap.Error(err))
-			doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
+			quarantineSave(s, pkt.RawDataPayload, fmt.Sprintf("diff decompression failed: %s", err))
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
 			return
 		}
 		// Perform diff.
 		s.logger.Info("Diffing...")
-		characterSaveData.decompSave = deltacomp.ApplyDataDiff(diff, characterSaveData.decompSave)
+		characterSaveData.decompSave, err = deltacomp.ApplyDataDiff(diff, characterSaveData.decompSave)
+		if err != nil {
+			quarantineSave(s, pkt.RawDataPayload, fmt.Sprintf("diff application failed: %s", err))
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			return
+		}
 	} else {
 		dumpSaveData(s, pkt.RawDataPayload, "savedata")
 		// Regular blob update.
 		saveData, err := nullcomp.Decompress(pkt.RawDataPayload)
 		if err != nil {
-			s.logger.Error("Failed to decompress savedata from packet", zap.Error(err))
-			doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
+			quarantineSave(s, pkt.RawDataPayload, fmt.Sprintf("decompression failed: %s", err))
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
 			return
 		}
 		if s.server.erupeConfig.SaveDumps.RawEnabled {
@@ -52,27 +63,20 @@ func handleMsgMhfSavedata(s *Session, p mhfpacket.MHFPacket) {
 		s.logger.Info("Updating save with blob")
 		characterSaveData.decompSave = saveData
 	}
-	characterSaveData.updateStructWithSaveData()
 
+	if err = characterSaveData.validate(s, storedLength); err != nil {
+		quarantineSave(s, characterSaveData.decompSave, err.Error())
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+
+	s.saveQuarantined = false
+	s.lastSave = time.Now()
 	s.playtime = characterSaveData.Playtime
 	s.playtimeTime = time.Now()
 
-	// Bypass name-checker if new
-	if characterSaveData.IsNewCharacter == true {
-		s.Name = characterSaveData.Name
-	}
-
-	if characterSaveData.Name == s.Name || _config.ErupeConfig.RealClientMode <= _config.S10 {
-		characterSaveData.Save(s)
-		s.logger.Info("Wrote recompressed savedata back to DB.")
-	} else {
-		s.rawConn.Close()
-		s.logger.Warn("Save cancelled due to corruption.")
-		if s.server.erupeConfig.DeleteOnSaveCorruption {
-			s.server.db.Exec("UPDATE characters SET deleted=true WHERE id=$1", s.charID)
-		}
-		return
-	}
+	characterSaveData.Save(s)
+	s.logger.Info("Wrote recompressed savedata back to DB.")
 	_, err = s.server.db.Exec("UPDATE characters SET name=$1 WHERE id=$2", characterSaveData.Name, s.charID)
 	if err != nil {
 		s.logger.Error("Failed to update character name in db", zap.Error(err))
@@ -80,6 +84,18 @@ func handleMsgMhfSavedata(s *Session, p mhfpacket.MHFPacket) {
 	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 }
 
+// quarantineSave keeps a rejected save for inspection and notifies operators.
+// The stored save is left untouched, so the character continues from its last good save.
+func quarantineSave(s *Session, data []byte, reason string) {
+	s.logger.Warn("Save quarantined due to corruption", zap.Uint32("charID", s.charID), zap.String("reason", reason))
+	s.saveQuarantined = true
+	_, err := s.server.db.Exec(`INSERT INTO savedata_quarantine (character_id, savedata, reason) VALUES ($1, $2, $3)`, s.charID, data, reason)
+	if err != nil {
+		s.logger.Error("Failed to quarantine savedata", zap.Error(err), zap.Uint32("charID", s.charID))
+	}
+	s.server.DiscordChannelSend(s.Name, fmt.Sprintf("Save rejected (%s), the last good save was kept", reason))
+}
+
 func grpToGR(n int) uint16 {
 	var gr int
 	a := []int{208750, 593400, 993400, 1400900, 2315900, 3340900, 4505900, 5850900, 7415900, 9230900, 11345900, 100000000}
@@ -94,6 +94,8 @@ func (s *Server) onInteraction(ds *discordgo.Session, i *discordgo.InteractionCr
 		password, _ := bcrypt.GenerateFromPassword([]byte(i.ApplicationCommandData().Options[0].StringValue()), 10)
 		_, err := s.db.Exec(`UPDATE users SET password = $1 WHERE discord_id = $2`, password, i.Member.User.ID)
 		if err == nil {
+			s.db.Exec(`DELETE FROM sign_sessions WHERE user_id = (SELECT id FROM users WHERE discord_id = $1)`, i.Member.User.ID)
+			s.db.Exec(`INSERT INTO password_reset_log (user_id, method, success) SELECT id, 'discord', true FROM users WHERE discord_id = $1`, i.Member.User.ID)
 			ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
 				Type: discordgo.InteractionResponseChannelMessageWithSource,
 				Data: &discordgo.InteractionResponseData{
@@ -110,6 +112,34 @@ func (s *Server) onInteraction(ds *discordgo.Session, i *discordgo.InteractionCr
 				},
 			})
 		}
+	case "where":
+		presence, err := FindPresenceByName(s.db, i.ApplicationCommandData().Options[0].StringValue())
+		var content string
+		if err != nil || len(presence) == 0 {
+			content = "Character not found."
+		}
+		for _, p := range presence {
+			if p.Online && p.Channel != nil {
+				var world string
+				if p.WorldName != nil {
+					world = *p.WorldName
+				}
+				content += fmt.Sprintf("%s is online on %s Channel %d", p.Name, world, *p.Channel)
+				if p.InQuest {
+					content += " (on a quest)"
+				}
+			} else {
+				content += fmt.Sprintf("%s was last seen <t:%d:R>", p.Name, p.LastSeen.Unix())
+			}
+			content += "\n"
+		}
+		ds.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+			Type: discordgo.InteractionResponseChannelMessageWithSource,
+			Data: &discordgo.InteractionResponseData{
+				Content: content,
+				Flags:   discordgo.MessageFlagsEphemeral,
+			},
+		})
 	}
 }
 
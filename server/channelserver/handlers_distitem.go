@@ -159,9 +159,37 @@ func handleMsgMhfApplyDistItem(s *Session, p mhfpacket.MHFPacket) {
 	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
 
+// rankGate returns the HR and GR ranges a character must be within to accept the distribution.
+func (d Distribution) rankGate() rankGate {
+	return rankGate{d.MinHR, d.MaxHR, d.MinGR, d.MaxGR}
+}
+
+// distributionAcceptable reports whether the character may accept the distribution, checking its
+// recipient, deadline, acceptance limit and rank gates.
+func distributionAcceptable(s *Session, distributionID uint32) bool {
+	var available bool
+	var dist Distribution
+	err := s.server.db.QueryRow(`SELECT (d.character_id IS NULL OR d.character_id = $2)
+		AND (d.deadline IS NULL OR d.deadline > $3)
+		AND (SELECT COUNT(*) FROM distributions_accepted da WHERE da.distribution_id = d.id AND da.character_id = $2) < d.times_acceptable,
+		COALESCE(d.min_hr, -1), COALESCE(d.max_hr, -1), COALESCE(d.min_gr, -1), COALESCE(d.max_gr, -1)
+		FROM distribution d WHERE d.id = $1`, distributionID, s.charID, TimeAdjusted().UTC()).Scan(&available,
+		&dist.MinHR, &dist.MaxHR, &dist.MinGR, &dist.MaxGR)
+	if err != nil {
+		s.logger.Error("Failed to check distribution", zap.Error(err), zap.Uint32("distributionID", distributionID))
+		return false
+	}
+	hr, gr, err := getCharacterRanks(s.server.db, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to get character ranks", zap.Error(err))
+		return false
+	}
+	return available && dist.rankGate().allows(hr, gr)
+}
+
 func handleMsgMhfAcquireDistItem(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfAcquireDistItem)
-	if pkt.DistributionID > 0 {
+	if pkt.DistributionID > 0 && distributionAcceptable(s, pkt.DistributionID) {
 		_, err := s.server.db.Exec(`INSERT INTO public.distributions_accepted VALUES ($1, $2)`, pkt.DistributionID, s.charID)
 		if err == nil {
 			distItems := getDistributionItems(s, pkt.DistributionID)
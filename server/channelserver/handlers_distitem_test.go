@@ -0,0 +1,26 @@
+package channelserver
+
+import "testing"
+
+func TestDistributionRankGate(t *testing.T) {
+	// SR bounds are sent to the client but not enforced
+	dist := Distribution{MinHR: 100, MaxHR: 999, MinSR: 50, MaxSR: -1, MinGR: -1, MaxGR: 200}
+	tests := []struct {
+		hr, gr int16
+		want   bool
+	}{
+		{999, 1, true},
+		{100, 200, true},
+		{99, 1, false},
+		{999, 201, false},
+	}
+	for _, tt := range tests {
+		if got := dist.rankGate().allows(tt.hr, tt.gr); got != tt.want {
+			t.Errorf("allows(HR %d, GR %d) = %t, want %t", tt.hr, tt.gr, got, tt.want)
+		}
+	}
+	ungated := Distribution{MinHR: -1, MaxHR: -1, MinGR: -1, MaxGR: -1}
+	if !ungated.rankGate().allows(0, 0) {
+		t.Error("allows() = false for a distribution without rank gates")
+	}
+}
@@ -0,0 +1,280 @@
+package channelserver
+
+import (
+	"database/sql"
+	_config "erupe-ce/config"
+	"sync"
+	"time"
+
+	"erupe-ce/common/byteframe"
+	"erupe-ce/network/mhfpacket"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// earthLock serialises rotation changes and reward grants across channels.
+var earthLock sync.Mutex
+
+// EarthPhase is the current phase of the Conquest War rotation.
+type EarthPhase struct {
+	ID       uint32
+	Phase    int
+	Status   int32
+	Monsters []int32
+	Start    time.Time
+	End      time.Time
+}
+
+type earthScheduleEntry struct {
+	Phase    int           `db:"phase"`
+	Status   int32         `db:"status"`
+	Duration int           `db:"duration"`
+	Monsters pq.Int32Array `db:"monsters"`
+}
+
+func getEarthSchedule(db *sqlx.DB) ([]earthScheduleEntry, error) {
+	var schedule []earthScheduleEntry
+	err := db.Select(&schedule, `SELECT phase, status, duration, monsters FROM earth_schedule WHERE duration > 0 ORDER BY phase`)
+	return schedule, err
+}
+
+// getEarthRotation returns the ID and start of the latest Conquest War rotation, or 0 if none has started.
+func getEarthRotation(db *sqlx.DB) (uint32, time.Time, error) {
+	var id, startTS uint32
+	err := db.QueryRow(`SELECT id, (EXTRACT(epoch FROM start_time)::int) FROM events WHERE event_type='earth' ORDER BY id DESC LIMIT 1`).Scan(&id, &startTS)
+	if err == sql.ErrNoRows {
+		return 0, TimeWeekStart(), nil
+	}
+	return id, time.Unix(int64(startTS), 0), err
+}
+
+// earthRotationStart returns the start of the rotation running at now, given the start of an earlier rotation.
+func earthRotationStart(schedule []earthScheduleEntry, start time.Time, now time.Time) time.Time {
+	var total time.Duration
+	for _, entry := range schedule {
+		total += time.Duration(entry.Duration) * time.Second
+	}
+	if total <= 0 {
+		return start
+	}
+	for !now.Before(start.Add(total)) {
+		start = start.Add(total)
+	}
+	return start
+}
+
+// earthPhase returns the phase of a rotation starting at start that is running at now.
+func earthPhase(schedule []earthScheduleEntry, start time.Time, now time.Time) EarthPhase {
+	var phase EarthPhase
+	end := earthRotationStart(schedule, start, now)
+	for _, entry := range schedule {
+		phase.Start = end
+		end = end.Add(time.Duration(entry.Duration) * time.Second)
+		phase.End = end
+		phase.Phase = entry.Phase
+		phase.Status = entry.Status
+		phase.Monsters = entry.Monsters
+		if now.Before(end) {
+			break
+		}
+	}
+	return phase
+}
+
+// getEarth returns the current Conquest War phase without side effects; rotations are
+// started by manageEarth. Without a schedule the static values from the config are used.
+func getEarth(s *Session) EarthPhase {
+	schedule, err := getEarthSchedule(s.server.db)
+	if err != nil {
+		s.logger.Error("Failed to get earth schedule", zap.Error(err))
+	}
+	if len(schedule) == 0 {
+		return EarthPhase{
+			ID:       uint32(s.server.erupeConfig.EarthID),
+			Status:   s.server.erupeConfig.EarthStatus,
+			Monsters: s.server.erupeConfig.EarthMonsters,
+			Start:    TimeWeekStart(),
+			End:      TimeWeekNext(),
+		}
+	}
+	id, start, err := getEarthRotation(s.server.db)
+	if err != nil {
+		s.logger.Error("Failed to get earth rotation", zap.Error(err))
+	}
+	phase := earthPhase(schedule, start, TimeAdjusted())
+	phase.ID = id
+	return phase
+}
+
+// manageEarth starts Conquest War rotations and grants the rewards of ended phases until the server shuts down.
+func (s *Server) manageEarth() {
+	for {
+		s.Lock()
+		shutdown := s.isShuttingDown
+		s.Unlock()
+		if shutdown {
+			return
+		}
+		s.advanceEarth()
+		time.Sleep(time.Minute)
+	}
+}
+
+// advanceEarth grants the rewards of every ended phase of the latest rotation, and starts
+// the next rotation once the latest one has ended.
+func (s *Server) advanceEarth() {
+	schedule, err := getEarthSchedule(s.db)
+	if err != nil {
+		s.logger.Error("Failed to get earth schedule", zap.Error(err))
+		return
+	} else if len(schedule) == 0 {
+		return
+	}
+
+	earthLock.Lock()
+	defer earthLock.Unlock()
+
+	id, start, err := getEarthRotation(s.db)
+	if err != nil {
+		s.logger.Error("Failed to get earth rotation", zap.Error(err))
+		return
+	}
+	now := TimeAdjusted()
+	if id > 0 {
+		s.grantEarthRewards(id, start, schedule, now)
+	}
+	if next := earthRotationStart(schedule, start, now); id == 0 || !next.Equal(start) {
+		_, err = s.db.Exec(`INSERT INTO events (event_type, start_time) VALUES ('earth', to_timestamp($1)::timestamp without time zone)
+			ON CONFLICT (start_time) WHERE event_type='earth' DO NOTHING`, next.Unix())
+		if err != nil {
+			s.logger.Error("Failed to start earth rotation", zap.Error(err))
+		}
+	}
+}
+
+// grantEarthRewards distributes the rewards of every ended phase of a rotation to the characters
+// that contributed during that phase.
+func (s *Server) grantEarthRewards(earthID uint32, start time.Time, schedule []earthScheduleEntry, now time.Time) {
+	end := start
+	for _, entry := range schedule {
+		end = end.Add(time.Duration(entry.Duration) * time.Second)
+		if now.Before(end) {
+			return
+		}
+		if err := s.grantEarthPhaseRewards(earthID, entry.Phase); err != nil {
+			s.logger.Error("Failed to grant earth rewards", zap.Error(err), zap.Uint32("earthID", earthID), zap.Int("phase", entry.Phase))
+			return
+		}
+	}
+}
+
+// grantEarthPhaseRewards distributes the rewards of one phase, once per rotation.
+func (s *Server) grantEarthPhaseRewards(earthID uint32, phase int) error {
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO earth_rewards_granted (earth_id, phase) VALUES ($1, $2) ON CONFLICT DO NOTHING`, earthID, phase)
+	if err != nil {
+		return err
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	_, err = tx.Exec(`WITH contributors AS (
+			SELECT DISTINCT character_id FROM earth_values WHERE earth_id=$1 AND phase=$2 AND value > 0
+		), rewards AS (
+			SELECT r.item_type, r.item_id, r.quantity FROM earth_rewards r WHERE r.phase=$2 AND r.threshold <= (
+				SELECT COALESCE(SUM(value), 0) FROM earth_values WHERE earth_id=$1 AND phase=$2 AND value_type=r.value_type)
+		), dists AS (
+			INSERT INTO distribution (character_id, type, event_name, description, times_acceptable)
+			SELECT character_id, 1, 'Conquest War', '~C05Rewards for your contribution to the Conquest War.', 1
+			FROM contributors WHERE EXISTS (SELECT 1 FROM rewards)
+			RETURNING id
+		)
+		INSERT INTO distribution_items (distribution_id, item_type, item_id, quantity)
+		SELECT d.id, r.item_type, r.item_id, r.quantity FROM dists d CROSS JOIN rewards r`, earthID, phase)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func handleMsgMhfGetEarthStatus(s *Session, p mhfpacket.MHFPacket) {
+	pkt := p.(*mhfpacket.MsgMhfGetEarthStatus)
+	earth := getEarth(s)
+	bf := byteframe.NewByteFrame()
+	bf.WriteUint32(uint32(earth.Start.Unix())) // Start
+	bf.WriteUint32(uint32(earth.End.Unix()))   // End
+	bf.WriteInt32(earth.Status)
+	bf.WriteInt32(int32(earth.ID))
+	for i := 0; i < 4; i++ {
+		if _config.ErupeConfig.RealClientMode <= _config.G9 && i == 3 {
+			break
+		}
+		if i < len(earth.Monsters) {
+			bf.WriteInt32(earth.Monsters[i])
+		} else {
+			bf.WriteInt32(0)
+		}
+	}
+	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
+}
+
+// handleMsgMhfRegistSpabiTime is left unhandled until the layout of the packet is confirmed,
+// so no Conquest War values are recorded from the client yet.
+func handleMsgMhfRegistSpabiTime(s *Session, p mhfpacket.MHFPacket) {}
+
+func handleMsgMhfGetEarthValue(s *Session, p mhfpacket.MHFPacket) {
+	pkt := p.(*mhfpacket.MsgMhfGetEarthValue)
+	type EarthValues struct {
+		Value []uint32
+	}
+
+	earth := getEarth(s)
+	var earthValues []EarthValues
+	switch pkt.ReqType {
+	case 1, 2:
+		// 1 is the values contributed by the character, 2 the server-wide totals
+		values := map[uint32]uint32{1: 0, 2: 0}
+		var rows *sql.Rows
+		var err error
+		if pkt.ReqType == 1 {
+			rows, err = s.server.db.Query(`SELECT value_type, value FROM earth_values WHERE earth_id=$1 AND phase=$2 AND character_id=$3`,
+				earth.ID, earth.Phase, s.charID)
+		} else {
+			rows, err = s.server.db.Query(`SELECT value_type, SUM(value) FROM earth_values WHERE earth_id=$1 AND phase=$2 GROUP BY value_type`,
+				earth.ID, earth.Phase)
+		}
+		if err == nil {
+			for rows.Next() {
+				var valueType, value uint32
+				if rows.Scan(&valueType, &value) == nil {
+					values[valueType] = value
+				}
+			}
+			rows.Close()
+		}
+		for valueType := uint32(1); valueType <= 2; valueType++ {
+			earthValues = append(earthValues, EarthValues{[]uint32{valueType, values[valueType], 0, 0, 0, 0}})
+		}
+	case 3:
+		earthValues = []EarthValues{
+			{[]uint32{1001, 36, 0, 0, 0, 0}},
+			{[]uint32{9001, 3, 0, 0, 0, 0}},
+			{[]uint32{9002, 10, 300, 0, 0, 0}},
+		}
+	}
+
+	var data []*byteframe.ByteFrame
+	for _, i := range earthValues {
+		bf := byteframe.NewByteFrame()
+		for _, j := range i.Value {
+			bf.WriteUint32(j)
+		}
+		data = append(data, bf)
+	}
+	doAckEarthIDSucceed(s, pkt.AckHandle, earth.ID, data)
+}
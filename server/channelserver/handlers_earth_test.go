@@ -0,0 +1,35 @@
+package channelserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEarthPhase(t *testing.T) {
+	schedule := []earthScheduleEntry{
+		{Phase: 1, Status: 1, Duration: 3600},
+		{Phase: 2, Status: 2, Duration: 7200},
+	}
+	start := time.Unix(1700000000, 0)
+	tests := []struct {
+		offset time.Duration
+		phase  int
+		start  time.Duration
+	}{
+		{0, 1, 0},
+		{59 * time.Minute, 1, 0},
+		{time.Hour, 2, time.Hour},
+		{3*time.Hour - time.Second, 2, time.Hour},
+		{3 * time.Hour, 1, 3 * time.Hour},
+		{7 * time.Hour, 2, 7 * time.Hour},
+	}
+	for _, tt := range tests {
+		got := earthPhase(schedule, start, start.Add(tt.offset))
+		if got.Phase != tt.phase || !got.Start.Equal(start.Add(tt.start)) {
+			t.Errorf("earthPhase() at +%s = phase %d from %s, want phase %d from +%s", tt.offset, got.Phase, got.Start, tt.phase, tt.start)
+		}
+	}
+	if got := earthRotationStart(schedule, start, start.Add(7*time.Hour)); !got.Equal(start.Add(6 * time.Hour)) {
+		t.Errorf("earthRotationStart() = %s, want +6h", got)
+	}
+}
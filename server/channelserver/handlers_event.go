@@ -8,6 +8,9 @@ import (
 
 	"erupe-ce/common/byteframe"
 	"erupe-ce/network/mhfpacket"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
 )
 
 type Event struct {
@@ -21,11 +24,83 @@ type Event struct {
 	QuestFileIDs []uint16
 }
 
+// activeEventQuests returns the IDs of the event quests of a type within the active part of their rotation.
+func activeEventQuests(s *Session, questType int, now time.Time) []uint16 {
+	var questIDs []uint16
+	rows, err := s.server.db.Query(`SELECT id, quest_id, start_time, COALESCE(active_days, 0), COALESCE(inactive_days, 0) FROM event_quests
+		WHERE quest_type = $1 ORDER BY quest_id`, questType)
+	if err != nil {
+		s.logger.Error("Failed to get event quests", zap.Error(err))
+		return questIDs
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id uint32
+		var questID uint16
+		var startTime time.Time
+		var activeDays, inactiveDays int
+		if rows.Scan(&id, &questID, &startTime, &activeDays, &inactiveDays) != nil {
+			continue
+		}
+		active, err := rotateEventQuest(s.server.db, id, startTime, now, activeDays, inactiveDays)
+		if err != nil {
+			s.logger.Error("Failed to rotate event quest", zap.Error(err), zap.Uint32("id", id))
+			continue
+		}
+		if active {
+			questIDs = append(questIDs, questID)
+		}
+	}
+	return questIDs
+}
+
+// getEvents returns the events of the calendar running now. Events with an event quest type also
+// list the quests of that type currently active in the event_quests rotation.
+func getEvents(s *Session) []Event {
+	var events []Event
+	now := TimeAdjusted()
+	rows, err := s.server.db.Query(`SELECT event_type, param1, param2, param3, param4, start_time, end_time, quest_ids, event_quest_type
+		FROM event_calendar WHERE start_time <= $1 AND end_time > $1 ORDER BY start_time, id`, now)
+	if err != nil {
+		s.logger.Error("Failed to get event calendar", zap.Error(err))
+		return events
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var event Event
+		var start, end time.Time
+		var questIDs pq.Int32Array
+		var eventQuestType *int
+		err = rows.Scan(&event.EventType, &event.Unk1, &event.Unk2, &event.Unk3, &event.Unk4, &start, &end, &questIDs, &eventQuestType)
+		if err != nil {
+			s.logger.Error("Failed to scan event", zap.Error(err))
+			continue
+		}
+		// Unk5 and Unk6 are assumed to be the start and end of the event
+		event.Unk5 = uint32(start.Unix())
+		event.Unk6 = uint32(end.Unix())
+		for _, id := range questIDs {
+			event.QuestFileIDs = append(event.QuestFileIDs, uint16(id))
+		}
+		if eventQuestType != nil {
+			event.QuestFileIDs = append(event.QuestFileIDs, activeEventQuests(s, *eventQuestType, now)...)
+		}
+		if len(event.QuestFileIDs) > 255 {
+			event.QuestFileIDs = event.QuestFileIDs[:255]
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
 func handleMsgMhfEnumerateEvent(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateEvent)
 	bf := byteframe.NewByteFrame()
 
-	events := []Event{}
+	events := getEvents(s)
+	if len(events) > 255 {
+		events = events[:255]
+	}
 
 	bf.WriteUint8(uint8(len(events)))
 	for _, event := range events {
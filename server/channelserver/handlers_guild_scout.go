@@ -24,6 +24,11 @@ func handleMsgMhfPostGuildScout(s *Session, p mhfpacket.MHFPacket) {
 		return
 	}
 
+	if IsBlocked(s.server.db, pkt.CharID, s.charID) {
+		doAckBufFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+
 	guildInfo, err := GetGuildInfoByID(s, actorCharGuildData.GuildID)
 
 	if err != nil {
@@ -83,7 +83,7 @@ func GetMailListForCharacter(s *Session, charID uint32) ([]Mail, error) {
 	rows, err := s.server.db.Queryx(`
 		SELECT
 			m.id,
-			m.sender_id,
+			COALESCE(m.sender_id, 0) AS sender_id,
 			m.recipient_id,
 			m.subject,
 			m.read,
@@ -95,13 +95,13 @@ func GetMailListForCharacter(s *Session, charID uint32) ([]Mail, error) {
 			m.is_sys_message,
 			m.deleted,
 			m.locked,
-			c.name as sender_name
+			COALESCE(c.name, $2) as sender_name
 		FROM mail m
-			JOIN characters c ON c.id = m.sender_id
+			LEFT JOIN characters c ON c.id = m.sender_id
 		WHERE recipient_id = $1 AND m.deleted = false
 		ORDER BY m.created_at DESC, id DESC
 		LIMIT 32
-	`, charID)
+	`, charID, systemMailSender)
 
 	if err != nil {
 		s.logger.Error("failed to get mail for character", zap.Error(err), zap.Uint32("charID", charID))
@@ -131,7 +131,7 @@ func GetMailByID(s *Session, ID int) (*Mail, error) {
 	row := s.server.db.QueryRowx(`
 		SELECT
 			m.id,
-			m.sender_id,
+			COALESCE(m.sender_id, 0) AS sender_id,
 			m.recipient_id,
 			m.subject,
 			m.read,
@@ -144,12 +144,12 @@ func GetMailByID(s *Session, ID int) (*Mail, error) {
 			m.is_sys_message,
 			m.deleted,
 			m.locked,
-			c.name as sender_name
+			COALESCE(c.name, $2) as sender_name
 		FROM mail m
-			JOIN characters c ON c.id = m.sender_id
+			LEFT JOIN characters c ON c.id = m.sender_id
 		WHERE m.id = $1
 		LIMIT 1
-	`, ID)
+	`, ID, systemMailSender)
 
 	mail := &Mail{}
 
@@ -171,7 +171,10 @@ func SendMailNotification(s *Session, m *Mail, recipient *Session) {
 	bf := byteframe.NewByteFrame()
 
 	notification := &binpacket.MsgBinMailNotify{
-		SenderName: getCharacterName(s, m.SenderID),
+		SenderName: systemMailSender,
+	}
+	if m.SenderID != 0 {
+		notification.SenderName = getCharacterName(s, m.SenderID)
 	}
 
 	notification.Build(bf)
@@ -226,6 +229,8 @@ func handleMsgMhfReadMail(s *Session, p mhfpacket.MHFPacket) {
 func handleMsgMhfListMail(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfListMail)
 
+	expireMail(s)
+
 	mail, err := GetMailListForCharacter(s, s.charID)
 	if err != nil {
 		doAckBufSucceed(s, pkt.AckHandle, []byte{0})
@@ -296,7 +301,7 @@ func handleMsgMhfOprtMail(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfOprtMail)
 
 	mail, err := GetMailByID(s, s.mailList[pkt.AccIndex])
-	if err != nil {
+	if err != nil || mail.RecipientID != s.charID {
 		doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 		return
 	}
@@ -309,7 +314,19 @@ func handleMsgMhfOprtMail(s *Session, p mhfpacket.MHFPacket) {
 	case mhfpacket.OperateMailUnlock:
 		s.server.db.Exec(`UPDATE mail SET locked = FALSE WHERE id = $1`, mail.ID)
 	case mhfpacket.OperateMailAcquireItem:
-		s.server.db.Exec(`UPDATE mail SET attached_item_received = TRUE WHERE id = $1`, mail.ID)
+		// Only the first request may claim the attachment
+		res, err := s.server.db.Exec(`UPDATE mail SET attached_item_received = TRUE WHERE id = $1 AND recipient_id = $2
+			AND attached_item_received = FALSE AND COALESCE(attached_item, 0) <> 0`, mail.ID, s.charID)
+		if err != nil {
+			s.logger.Error("Failed to claim mail attachment", zap.Error(err), zap.Int("mailID", mail.ID))
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			return
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			s.logger.Warn("Mail attachment already claimed", zap.Int("mailID", mail.ID), zap.Uint32("charID", s.charID))
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			return
+		}
 	}
 	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 }
@@ -321,32 +338,148 @@ func handleMsgMhfSendMail(s *Session, p mhfpacket.MHFPacket) {
 		VALUES ($1, $2, $3, $4, $5, $6, $7)
 	`
 
+	// The send is recorded in the same transaction as the mail so that it only counts once sent
+	tx, err := s.server.db.Begin()
+	if err != nil {
+		s.logger.Error("Failed to send mail", zap.Error(err))
+		doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+	if !reserveMailSend(s, tx) {
+		tx.Rollback()
+		s.logger.Warn("Mail send limit reached", zap.Uint32("charID", s.charID))
+		doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+		return
+	}
+
 	if pkt.RecipientID == 0 { // Guild mail
 		g, err := GetGuildInfoByCharacterId(s, s.charID)
 		if err != nil {
+			tx.Rollback()
 			s.logger.Error("Failed to get guild info for mail")
 			doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 			return
 		}
 		gm, err := GetGuildMembers(s, g.ID, false)
 		if err != nil {
+			tx.Rollback()
 			s.logger.Error("Failed to get guild members for mail")
 			doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 			return
 		}
 		for i := 0; i < len(gm); i++ {
-			_, err := s.server.db.Exec(query, s.charID, gm[i].CharID, pkt.Subject, pkt.Body, 0, 0, false)
+			if IsBlocked(s.server.db, gm[i].CharID, s.charID) || !mailboxHasSpace(s, tx, gm[i].CharID) {
+				continue
+			}
+			_, err := tx.Exec(query, s.charID, gm[i].CharID, pkt.Subject, pkt.Body, 0, 0, false)
 			if err != nil {
+				tx.Rollback()
 				s.logger.Error("Failed to send mail")
 				doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 				return
 			}
 		}
 	} else {
-		_, err := s.server.db.Exec(query, s.charID, pkt.RecipientID, pkt.Subject, pkt.Body, pkt.ItemID, pkt.Quantity, false)
+		if IsBlocked(s.server.db, pkt.RecipientID, s.charID) {
+			// Dropped without telling the sender they are blocked
+			tx.Rollback()
+			s.logger.Debug("Mail blocked by recipient", zap.Uint32("charID", s.charID), zap.Uint32("recipientID", pkt.RecipientID))
+			doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
+			return
+		}
+		if !mailboxHasSpace(s, tx, pkt.RecipientID) {
+			tx.Rollback()
+			doAckSimpleFail(s, pkt.AckHandle, make([]byte, 4))
+			return
+		}
+		_, err := tx.Exec(query, s.charID, pkt.RecipientID, pkt.Subject, pkt.Body, pkt.ItemID, pkt.Quantity, false)
 		if err != nil {
+			tx.Rollback()
 			s.logger.Error("Failed to send mail")
+			doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
+			return
 		}
 	}
+	if err = tx.Commit(); err != nil {
+		s.logger.Error("Failed to send mail", zap.Error(err))
+	}
 	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
 }
+
+// reserveMailSend records a send in the transaction if the character is within the configured send
+// limit, reporting whether it may send. Concurrent sends by the character wait on an advisory lock
+// until the transaction ends, so they cannot both pass the limit.
+func reserveMailSend(s *Session, tx *sql.Tx) bool {
+	opts := s.server.erupeConfig.Mail
+	if opts.SendLimit <= 0 {
+		return true
+	}
+	_, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext('mail_sends'), $1)`, s.charID)
+	if err != nil {
+		s.logger.Error("Failed to lock mail sends", zap.Error(err))
+		return false
+	}
+	// Sends outside the window no longer count
+	_, err = tx.Exec(`DELETE FROM mail_sends WHERE character_id = $1 AND sent_at <= now() - make_interval(secs => $2)`, s.charID, opts.SendWindow)
+	if err != nil {
+		s.logger.Error("Failed to remove old mail sends", zap.Error(err))
+		return false
+	}
+	var sent int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM mail_sends WHERE character_id = $1`, s.charID).Scan(&sent)
+	if err != nil {
+		s.logger.Error("Failed to count sent mail", zap.Error(err))
+		return false
+	}
+	if sent >= opts.SendLimit {
+		return false
+	}
+	if _, err = tx.Exec(`INSERT INTO mail_sends (character_id) VALUES ($1)`, s.charID); err != nil {
+		s.logger.Error("Failed to record mail send", zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// mailboxHasSpace removes the oldest read mail from a full mailbox in the transaction of the send
+// and reports whether there is room for more. Locked mail and mail with an unclaimed attachment are
+// never removed.
+func mailboxHasSpace(s *Session, tx *sql.Tx, recipientID uint32) bool {
+	size := s.server.erupeConfig.Mail.MailboxSize
+	if size <= 0 {
+		return true
+	}
+	_, err := tx.Exec(`UPDATE mail SET deleted = true WHERE id IN (
+			SELECT id FROM mail WHERE recipient_id = $1 AND deleted = false AND read = true AND locked = false
+			AND (COALESCE(attached_item, 0) = 0 OR attached_item_received = true)
+			ORDER BY created_at DESC, id DESC OFFSET GREATEST($2 - 1 - (
+				SELECT COUNT(*) FROM mail WHERE recipient_id = $1 AND deleted = false AND NOT (read = true AND locked = false
+				AND (COALESCE(attached_item, 0) = 0 OR attached_item_received = true))
+			), 0)
+		)`, recipientID, size)
+	if err != nil {
+		s.logger.Error("Failed to remove old mail", zap.Error(err), zap.Uint32("recipientID", recipientID))
+		return false
+	}
+	var count int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM mail WHERE recipient_id = $1 AND deleted = false`, recipientID).Scan(&count)
+	if err != nil {
+		s.logger.Error("Failed to count mail", zap.Error(err), zap.Uint32("recipientID", recipientID))
+		return false
+	}
+	return count < size
+}
+
+// expireMail removes the character's read mail older than the configured expiry.
+func expireMail(s *Session) {
+	days := s.server.erupeConfig.Mail.ReadExpiry
+	if days <= 0 {
+		return
+	}
+	_, err := s.server.db.Exec(`UPDATE mail SET deleted = true WHERE recipient_id = $1 AND deleted = false AND read = true
+		AND locked = false AND (COALESCE(attached_item, 0) = 0 OR attached_item_received = true)
+		AND created_at < now() - make_interval(days => $2)`, s.charID, days)
+	if err != nil {
+		s.logger.Error("Failed to expire mail", zap.Error(err), zap.Uint32("charID", s.charID))
+	}
+}
@@ -89,7 +89,12 @@ func handleMsgMhfSaveHunterNavi(s *Session, p mhfpacket.MHFPacket) {
 
 		// Perform diff and compress it to write back to db
 		s.logger.Info("Diffing...")
-		saveOutput := deltacomp.ApplyDataDiff(pkt.RawDataPayload, data)
+		saveOutput, err := deltacomp.ApplyDataDiff(pkt.RawDataPayload, data)
+		if err != nil {
+			s.logger.Error("Failed to diff hunternavi", zap.Error(err))
+			doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+			return
+		}
 		_, err = s.server.db.Exec("UPDATE characters SET hunternavi=$1 WHERE id=$2", saveOutput, s.charID)
 		if err != nil {
 			s.logger.Error("Failed to save hunternavi", zap.Error(err))
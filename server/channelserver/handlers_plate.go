@@ -47,9 +47,15 @@ func handleMsgMhfSavePlateData(s *Session, p mhfpacket.MHFPacket) {
 
 		// Perform diff and compress it to write back to db
 		s.logger.Info("Diffing...")
-		saveOutput, err := nullcomp.Compress(deltacomp.ApplyDataDiff(pkt.RawDataPayload, data))
+		data, err = deltacomp.ApplyDataDiff(pkt.RawDataPayload, data)
 		if err != nil {
-			s.logger.Error("Failed to diff and compress platedata", zap.Error(err))
+			s.logger.Error("Failed to diff platedata", zap.Error(err))
+			doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+			return
+		}
+		saveOutput, err := nullcomp.Compress(data)
+		if err != nil {
+			s.logger.Error("Failed to compress platedata", zap.Error(err))
 			doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 			return
 		}
@@ -115,9 +121,15 @@ func handleMsgMhfSavePlateBox(s *Session, p mhfpacket.MHFPacket) {
 
 		// Perform diff and compress it to write back to db
 		s.logger.Info("Diffing...")
-		saveOutput, err := nullcomp.Compress(deltacomp.ApplyDataDiff(pkt.RawDataPayload, data))
+		data, err = deltacomp.ApplyDataDiff(pkt.RawDataPayload, data)
+		if err != nil {
+			s.logger.Error("Failed to diff platebox", zap.Error(err))
+			doAckSimpleFail(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
+			return
+		}
+		saveOutput, err := nullcomp.Compress(data)
 		if err != nil {
-			s.logger.Error("Failed to diff and compress platebox", zap.Error(err))
+			s.logger.Error("Failed to compress platebox", zap.Error(err))
 			doAckSimpleSucceed(s, pkt.AckHandle, []byte{0x00, 0x00, 0x00, 0x00})
 			return
 		}
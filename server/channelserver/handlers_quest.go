@@ -4,16 +4,16 @@ import (
 	"database/sql"
 	"encoding/binary"
 	"erupe-ce/common/byteframe"
-	"erupe-ce/common/decryption"
+	"erupe-ce/common/jpk"
+	"erupe-ce/common/mhfcourse"
 	ps "erupe-ce/common/pascalstring"
 	_config "erupe-ce/config"
 	"erupe-ce/network/mhfpacket"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
 
@@ -103,14 +103,21 @@ func handleMsgSysGetFile(s *Session, p mhfpacket.MHFPacket) {
 		}
 		filename := fmt.Sprintf("%d_0_0_0_S%d_T%d_C%d", pkt.ScenarioIdentifer.CategoryID, pkt.ScenarioIdentifer.MainID, pkt.ScenarioIdentifer.Flags, pkt.ScenarioIdentifer.ChapterID)
 		// Read the scenario file.
-		data, err := os.ReadFile(filepath.Join(s.server.erupeConfig.BinPath, fmt.Sprintf("scenarios/%s.bin", filename)))
+		data, err := s.server.readBinFile(fmt.Sprintf("scenarios/%s.bin", filename))
 		if err != nil {
 			s.logger.Error(fmt.Sprintf("Failed to open file: %s/scenarios/%s.bin", s.server.erupeConfig.BinPath, filename))
-			// This will crash the game.
-			doAckBufSucceed(s, pkt.AckHandle, data)
-			return
+			if s.server.erupeConfig.FallbackScenario == "" {
+				doAckBufFail(s, pkt.AckHandle, nil)
+				return
+			}
+			data, err = s.server.readBinFile(fmt.Sprintf("scenarios/%s.bin", s.server.erupeConfig.FallbackScenario))
+			if err != nil {
+				s.logger.Error(fmt.Sprintf("Failed to open fallback scenario: %s/scenarios/%s.bin", s.server.erupeConfig.BinPath, s.server.erupeConfig.FallbackScenario))
+				doAckBufFail(s, pkt.AckHandle, nil)
+				return
+			}
 		}
-		doAckBufSucceed(s, pkt.AckHandle, data)
+		doAckBufSucceed(s, pkt.AckHandle, compressBinFile(s, data))
 	} else {
 		if s.server.erupeConfig.DebugOptions.QuestTools {
 			s.logger.Debug(
@@ -123,29 +130,49 @@ func handleMsgSysGetFile(s *Session, p mhfpacket.MHFPacket) {
 			pkt.Filename = seasonConversion(s, pkt.Filename)
 		}
 
-		data, err := os.ReadFile(filepath.Join(s.server.erupeConfig.BinPath, fmt.Sprintf("quests/%s.bin", pkt.Filename)))
+		data, err := s.server.readBinFile(fmt.Sprintf("quests/%s.bin", pkt.Filename))
 		if err != nil {
 			s.logger.Error(fmt.Sprintf("Failed to open file: %s/quests/%s.bin", s.server.erupeConfig.BinPath, pkt.Filename))
-			// This will crash the game.
-			doAckBufSucceed(s, pkt.AckHandle, data)
-			return
+			if s.server.erupeConfig.FallbackQuest == "" {
+				doAckBufFail(s, pkt.AckHandle, nil)
+				return
+			}
+			data, err = s.server.readBinFile(fmt.Sprintf("quests/%s.bin", s.server.erupeConfig.FallbackQuest))
+			if err != nil {
+				s.logger.Error(fmt.Sprintf("Failed to open fallback quest: %s/quests/%s.bin", s.server.erupeConfig.BinPath, s.server.erupeConfig.FallbackQuest))
+				doAckBufFail(s, pkt.AckHandle, nil)
+				return
+			}
 		}
 		if _config.ErupeConfig.RealClientMode <= _config.Z1 && s.server.erupeConfig.DebugOptions.AutoQuestBackport {
-			data = BackportQuest(decryption.UnpackSimple(data))
+			data = BackportQuest(jpk.Unpack(data))
 		}
-		doAckBufSucceed(s, pkt.AckHandle, data)
+		doAckBufSucceed(s, pkt.AckHandle, compressBinFile(s, data))
+	}
+}
+
+// compressBinFile JPK compresses a quest or scenario file if the config asks for it and it isn't already.
+func compressBinFile(s *Session, data []byte) []byte {
+	if !s.server.erupeConfig.CompressQuests || jpk.IsJPK(data) {
+		return data
 	}
+	compressed, err := jpk.Encode(jpk.TypeLZ, data)
+	if err != nil {
+		s.logger.Error("Failed to compress file", zap.Error(err))
+		return data
+	}
+	return compressed
 }
 
 func seasonConversion(s *Session, questFile string) string {
 	filename := fmt.Sprintf("%s%d", questFile[:6], s.server.Season())
 
 	// Return the seasonal file
-	if _, err := os.Stat(filepath.Join(s.server.erupeConfig.BinPath, fmt.Sprintf("quests/%s.bin", filename))); err == nil {
+	if s.server.binFileExists(fmt.Sprintf("quests/%s.bin", filename)) {
 		return filename
 	} else {
 		// Attempt to return the requested quest file if the seasonal file doesn't exist
-		if _, err = os.Stat(filepath.Join(s.server.erupeConfig.BinPath, fmt.Sprintf("quests/%s.bin", questFile))); err == nil {
+		if s.server.binFileExists(fmt.Sprintf("quests/%s.bin", questFile)) {
 			return questFile
 		}
 
@@ -183,17 +210,23 @@ func handleMsgMhfSaveFavoriteQuest(s *Session, p mhfpacket.MHFPacket) {
 }
 
 func loadQuestFile(s *Session, questId int) []byte {
+	// Quests cached from a pack that has since been swapped out are read again
+	pack := s.server.binFiles.Pack()
+	s.server.questCacheLock.RLock()
 	data, exists := s.server.questCacheData[questId]
-	if exists && s.server.questCacheTime[questId].Add(time.Duration(s.server.erupeConfig.QuestCacheExpiry)*time.Second).After(time.Now()) {
+	cacheTime := s.server.questCacheTime[questId]
+	cachePack := s.server.questCachePack[questId]
+	s.server.questCacheLock.RUnlock()
+	if exists && cachePack == pack && cacheTime.Add(time.Duration(s.server.erupeConfig.QuestCacheExpiry)*time.Second).After(time.Now()) {
 		return data
 	}
 
-	file, err := os.ReadFile(filepath.Join(s.server.erupeConfig.BinPath, fmt.Sprintf("quests/%05dd0.bin", questId)))
+	file, err := s.server.binFiles.readFrom(pack, fmt.Sprintf("quests/%05dd0.bin", questId))
 	if err != nil {
 		return nil
 	}
 
-	decrypted := decryption.UnpackSimple(file)
+	decrypted := jpk.Unpack(file)
 	if _config.ErupeConfig.RealClientMode <= _config.Z1 && s.server.erupeConfig.DebugOptions.AutoQuestBackport {
 		decrypted = BackportQuest(decrypted)
 	}
@@ -241,6 +274,7 @@ func loadQuestFile(s *Session, questId int) []byte {
 	s.server.questCacheLock.Lock()
 	s.server.questCacheData[questId] = questBody.Data()
 	s.server.questCacheTime[questId] = time.Now()
+	s.server.questCachePack[questId] = pack
 	s.server.questCacheLock.Unlock()
 	return questBody.Data()
 }
@@ -321,6 +355,39 @@ func makeEventQuest(s *Session, rows *sql.Rows) ([]byte, error) {
 	return bf.Data(), nil
 }
 
+// eventQuestRotation returns the start of the current rotation of an event quest cycling through
+// activeDays on and inactiveDays off since startTime, and whether now falls within its active days.
+// Quests without active days are always active.
+func eventQuestRotation(startTime, now time.Time, activeDays, inactiveDays int) (time.Time, bool) {
+	if activeDays <= 0 {
+		return startTime, true
+	}
+	cycleLength := time.Duration(activeDays+inactiveDays) * 24 * time.Hour
+
+	// Count the number of full cycles elapsed since the last rotation.
+	extraCycles := int(now.Sub(startTime) / cycleLength)
+	if extraCycles > 0 {
+		rotationTime := startTime.Add(cycleLength * time.Duration(extraCycles))
+		if now.After(rotationTime) {
+			// Normalize rotationTime to 12PM JST to align with the in-game events update notification.
+			startTime = time.Date(rotationTime.Year(), rotationTime.Month(), rotationTime.Day(), 12, 0, 0, 0, now.Location())
+		}
+	}
+	return startTime, !now.Before(startTime) && !now.After(startTime.Add(time.Duration(activeDays)*24*time.Hour))
+}
+
+// rotateEventQuest moves the start_time of an event quest to its current rotation and reports whether
+// it is active at now.
+func rotateEventQuest(db sqlx.Execer, id uint32, startTime, now time.Time, activeDays, inactiveDays int) (bool, error) {
+	rotation, active := eventQuestRotation(startTime, now, activeDays, inactiveDays)
+	if !rotation.Equal(startTime) {
+		if _, err := db.Exec("UPDATE event_quests SET start_time = $1 WHERE id = $2", rotation, id); err != nil {
+			return false, err
+		}
+	}
+	return active, nil
+}
+
 func handleMsgMhfEnumerateQuest(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfEnumerateQuest)
 	var totalCount, returnedCount uint16
@@ -329,7 +396,7 @@ func handleMsgMhfEnumerateQuest(s *Session, p mhfpacket.MHFPacket) {
 
 	rows, err := s.server.db.Query("SELECT id, COALESCE(max_players, 4) AS max_players, quest_type, quest_id, COALESCE(mark, 0) AS mark, COALESCE(flags, -1), start_time, COALESCE(active_days, 0) AS active_days, COALESCE(inactive_days, 0) AS inactive_days FROM event_quests ORDER BY quest_id")
 	if err == nil {
-		currentTime := time.Now()
+		currentTime := TimeAdjusted()
 		tx, _ := s.server.db.Begin()
 
 		for rows.Next() {
@@ -345,32 +412,13 @@ func handleMsgMhfEnumerateQuest(s *Session, p mhfpacket.MHFPacket) {
 			}
 
 			// Use the Event Cycling system
-			if activeDays > 0 {
-				cycleLength := (time.Duration(activeDays) + time.Duration(inactiveDays)) * 24 * time.Hour
-
-				// Count the number of full cycles elapsed since the last rotation.
-				extraCycles := int(currentTime.Sub(startTime) / cycleLength)
-
-				if extraCycles > 0 {
-					// Calculate the rotation time based on start time, active duration, and inactive duration.
-					rotationTime := startTime.Add(time.Duration(activeDays+inactiveDays) * 24 * time.Hour * time.Duration(extraCycles))
-					if currentTime.After(rotationTime) {
-						// Normalize rotationTime to 12PM JST to align with the in-game events update notification.
-						newRotationTime := time.Date(rotationTime.Year(), rotationTime.Month(), rotationTime.Day(), 12, 0, 0, 0, TimeAdjusted().Location())
-
-						_, err = tx.Exec("UPDATE event_quests SET start_time = $1 WHERE id = $2", newRotationTime, id)
-						if err != nil {
-							tx.Rollback() // Rollback if an error occurs
-							break
-						}
-						startTime = newRotationTime // Set the new start time so the quest can be used/removed immediately.
-					}
-				}
-
-				// Check if the quest is currently active
-				if currentTime.Before(startTime) || currentTime.After(startTime.Add(time.Duration(activeDays)*24*time.Hour)) {
-					continue
-				}
+			active, err := rotateEventQuest(tx, id, startTime, currentTime, activeDays, inactiveDays)
+			if err != nil {
+				tx.Rollback() // Rollback if an error occurs
+				break
+			}
+			if !active {
+				continue
 			}
 
 			data, err := makeEventQuest(s, rows)
@@ -396,6 +444,86 @@ func handleMsgMhfEnumerateQuest(s *Session, p mhfpacket.MHFPacket) {
 		tx.Commit()
 	}
 
+	tuneValues := applyTuneValueOverrides(s, defaultTuneValues(s))
+
+	var temp []tuneValue
+	for i := range tuneValues {
+		if tuneValues[i].Value > 0 {
+			temp = append(temp, tuneValues[i])
+		}
+	}
+	tuneValues = temp
+
+	tuneLimit := 770
+	if _config.ErupeConfig.RealClientMode <= _config.G1 {
+		tuneLimit = 256
+	} else if _config.ErupeConfig.RealClientMode <= _config.G3 {
+		tuneLimit = 283
+	} else if _config.ErupeConfig.RealClientMode <= _config.GG {
+		tuneLimit = 315
+	} else if _config.ErupeConfig.RealClientMode <= _config.G61 {
+		tuneLimit = 332
+	} else if _config.ErupeConfig.RealClientMode <= _config.G7 {
+		tuneLimit = 339
+	} else if _config.ErupeConfig.RealClientMode <= _config.G81 {
+		tuneLimit = 396
+	} else if _config.ErupeConfig.RealClientMode <= _config.G91 {
+		tuneLimit = 694
+	} else if _config.ErupeConfig.RealClientMode <= _config.G101 {
+		tuneLimit = 704
+	} else if _config.ErupeConfig.RealClientMode <= _config.Z2 {
+		tuneLimit = 750
+	}
+	if len(tuneValues) > tuneLimit {
+		tuneValues = tuneValues[:tuneLimit]
+	}
+
+	offset := uint16(time.Now().Unix())
+	bf.WriteUint16(offset)
+
+	bf.WriteUint16(uint16(len(tuneValues)))
+	for i := range tuneValues {
+		bf.WriteUint16(tuneValues[i].ID ^ offset)
+		bf.WriteUint16(offset)
+		bf.WriteBytes(make([]byte, 4))
+		bf.WriteUint16(tuneValues[i].Value ^ offset)
+	}
+
+	vsQuestItems := []uint16{1580, 1581, 1582, 1583, 1584, 1585, 1587, 1588, 1589, 1595, 1596, 1597, 1598, 1599, 1600, 1601, 1602, 1603, 1604}
+	vsQuestBets := []struct {
+		IsTicket bool
+		Quantity uint32
+	}{
+		{true, 5},
+		{false, 1000},
+		{false, 5000},
+		{false, 10000},
+	}
+	bf.WriteUint16(uint16(len(vsQuestItems)))
+	bf.WriteUint16(0) // Unk array of uint16s
+	bf.WriteUint16(uint16(len(vsQuestBets)))
+	bf.WriteUint16(0) // Unk
+
+	for i := range vsQuestItems {
+		bf.WriteUint16(vsQuestItems[i])
+	}
+	for i := range vsQuestBets {
+		bf.WriteBool(vsQuestBets[i].IsTicket)
+		bf.WriteUint8(9)
+		bf.WriteUint16(7)
+		bf.WriteUint32(vsQuestBets[i].Quantity)
+	}
+
+	bf.WriteUint16(totalCount)
+	bf.WriteUint16(pkt.Offset)
+	bf.Seek(0, io.SeekStart)
+	bf.WriteUint16(returnedCount)
+
+	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
+}
+
+// defaultTuneValues returns the built-in quest tune values, including the rates set in the GameplayOptions.
+func defaultTuneValues(s *Session) []tuneValue {
 	tuneValues := []tuneValue{
 		{ID: 20, Value: 1},
 		{ID: 26, Value: 1},
@@ -570,81 +698,46 @@ func handleMsgMhfEnumerateQuest(s *Session, p mhfpacket.MHFPacket) {
 	// get_nboost_transcend_rate_from_grank
 	tuneValues = append(tuneValues, getTuneValueRange(3299, 200)...)
 	tuneValues = append(tuneValues, getTuneValueRange(3325, 300)...)
+	return tuneValues
+}
 
-	var temp []tuneValue
-	for i := range tuneValues {
-		if tuneValues[i].Value > 0 {
-			temp = append(temp, tuneValues[i])
+// applyTuneValueOverrides replaces or adds the tune values stored in the database that apply right now.
+// Rows with a time window or course condition take precedence over unconditional rows, and a value
+// of 0 removes the tune value.
+func applyTuneValueOverrides(s *Session, tuneValues []tuneValue) []tuneValue {
+	rows, err := s.server.db.Query(`SELECT tune_id, value, span, course_id FROM quest_tune_values
+		WHERE (start_time IS NULL OR start_time <= $1) AND (end_time IS NULL OR end_time > $1)
+		ORDER BY (start_time IS NOT NULL OR end_time IS NOT NULL OR course_id IS NOT NULL), id`, TimeAdjusted())
+	if err != nil {
+		s.logger.Error("Failed to get quest tune values", zap.Error(err))
+		return tuneValues
+	}
+	defer rows.Close()
+	index := make(map[uint16]int)
+	for i, tv := range tuneValues {
+		index[tv.ID] = i
+	}
+	for rows.Next() {
+		var id, value, span int
+		var course *uint16
+		if err = rows.Scan(&id, &value, &span, &course); err != nil {
+			s.logger.Error("Failed to scan quest tune value", zap.Error(err))
+			continue
+		}
+		if course != nil && !mhfcourse.CourseExists(*course, s.courses) {
+			continue
+		}
+		for i := 0; i < max(span, 1); i++ {
+			tv := tuneValue{uint16(id + i), uint16(value)}
+			if j, ok := index[tv.ID]; ok {
+				tuneValues[j] = tv
+			} else {
+				index[tv.ID] = len(tuneValues)
+				tuneValues = append(tuneValues, tv)
+			}
 		}
 	}
-	tuneValues = temp
-
-	tuneLimit := 770
-	if _config.ErupeConfig.RealClientMode <= _config.G1 {
-		tuneLimit = 256
-	} else if _config.ErupeConfig.RealClientMode <= _config.G3 {
-		tuneLimit = 283
-	} else if _config.ErupeConfig.RealClientMode <= _config.GG {
-		tuneLimit = 315
-	} else if _config.ErupeConfig.RealClientMode <= _config.G61 {
-		tuneLimit = 332
-	} else if _config.ErupeConfig.RealClientMode <= _config.G7 {
-		tuneLimit = 339
-	} else if _config.ErupeConfig.RealClientMode <= _config.G81 {
-		tuneLimit = 396
-	} else if _config.ErupeConfig.RealClientMode <= _config.G91 {
-		tuneLimit = 694
-	} else if _config.ErupeConfig.RealClientMode <= _config.G101 {
-		tuneLimit = 704
-	} else if _config.ErupeConfig.RealClientMode <= _config.Z2 {
-		tuneLimit = 750
-	}
-	if len(tuneValues) > tuneLimit {
-		tuneValues = tuneValues[:tuneLimit]
-	}
-
-	offset := uint16(time.Now().Unix())
-	bf.WriteUint16(offset)
-
-	bf.WriteUint16(uint16(len(tuneValues)))
-	for i := range tuneValues {
-		bf.WriteUint16(tuneValues[i].ID ^ offset)
-		bf.WriteUint16(offset)
-		bf.WriteBytes(make([]byte, 4))
-		bf.WriteUint16(tuneValues[i].Value ^ offset)
-	}
-
-	vsQuestItems := []uint16{1580, 1581, 1582, 1583, 1584, 1585, 1587, 1588, 1589, 1595, 1596, 1597, 1598, 1599, 1600, 1601, 1602, 1603, 1604}
-	vsQuestBets := []struct {
-		IsTicket bool
-		Quantity uint32
-	}{
-		{true, 5},
-		{false, 1000},
-		{false, 5000},
-		{false, 10000},
-	}
-	bf.WriteUint16(uint16(len(vsQuestItems)))
-	bf.WriteUint16(0) // Unk array of uint16s
-	bf.WriteUint16(uint16(len(vsQuestBets)))
-	bf.WriteUint16(0) // Unk
-
-	for i := range vsQuestItems {
-		bf.WriteUint16(vsQuestItems[i])
-	}
-	for i := range vsQuestBets {
-		bf.WriteBool(vsQuestBets[i].IsTicket)
-		bf.WriteUint8(9)
-		bf.WriteUint16(7)
-		bf.WriteUint32(vsQuestBets[i].Quantity)
-	}
-
-	bf.WriteUint16(totalCount)
-	bf.WriteUint16(pkt.Offset)
-	bf.Seek(0, io.SeekStart)
-	bf.WriteUint16(returnedCount)
-
-	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
+	return tuneValues
 }
 
 func getTuneValueRange(start uint16, value uint16) []tuneValue {
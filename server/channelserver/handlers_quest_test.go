@@ -0,0 +1,39 @@
+package channelserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventQuestRotation(t *testing.T) {
+	loc := time.FixedZone("UTC+9", 9*60*60)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, loc)
+	day := 24 * time.Hour
+	tests := []struct {
+		offset       time.Duration
+		activeDays   int
+		inactiveDays int
+		rotation     time.Duration
+		active       bool
+	}{
+		{10 * day, 0, 0, 0, true},
+		{-time.Hour, 3, 4, 0, false},
+		{2 * day, 3, 4, 0, true},
+		{5 * day, 3, 4, 0, false},
+		{7*day + time.Hour, 3, 4, 7 * day, true},
+		{7*day + 11*time.Hour, 3, 4, 7 * day, true},
+		{18 * day, 3, 4, 14 * day, false},
+	}
+	for _, tt := range tests {
+		rotation, active := eventQuestRotation(start, start.Add(tt.offset), tt.activeDays, tt.inactiveDays)
+		if !rotation.Equal(start.Add(tt.rotation)) || active != tt.active {
+			t.Errorf("eventQuestRotation() at +%s = %s, %t, want +%s, %t", tt.offset, rotation, active, tt.rotation, tt.active)
+		}
+	}
+
+	// Rotations are normalized to 12:00 of the day they fall on
+	rotation, _ := eventQuestRotation(start.Add(3*time.Hour), start.Add(7*day+4*time.Hour), 3, 4)
+	if want := start.Add(7 * day); !rotation.Equal(want) {
+		t.Errorf("eventQuestRotation() = %s, want %s", rotation, want)
+	}
+}
@@ -1,8 +1,14 @@
 package channelserver
 
 import (
+	"database/sql"
 	"erupe-ce/common/byteframe"
+	"erupe-ce/common/stringsupport"
 	"erupe-ce/network/mhfpacket"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
 )
 
 func handleMsgMhfGetBreakSeibatuLevelReward(s *Session, p mhfpacket.MHFPacket) {
@@ -27,8 +33,23 @@ type WeeklySeibatuRankingReward struct {
 func handleMsgMhfGetWeeklySeibatuRankingReward(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfGetWeeklySeibatuRankingReward)
 	var data []*byteframe.ByteFrame
-	weeklySeibatuRankingRewards := []WeeklySeibatuRankingReward{
-		{0, 0, 0, 0, 0, 0},
+	var weeklySeibatuRankingRewards []WeeklySeibatuRankingReward
+	// The fields of a reward are unconfirmed. They are assumed to be the lowest and highest rank,
+	// the item ID, the item type and the quantity, with Unk5 left zeroed.
+	rows, err := s.server.db.Query(`SELECT rank_min, rank_max, item_id, item_type, quantity FROM seibattle_ranking_rewards ORDER BY rank_min`)
+	if err == nil {
+		for rows.Next() {
+			var reward WeeklySeibatuRankingReward
+			if rows.Scan(&reward.Unk0, &reward.Unk1, &reward.Unk2, &reward.Unk3, &reward.Unk4) == nil {
+				weeklySeibatuRankingRewards = append(weeklySeibatuRankingRewards, reward)
+			}
+		}
+		rows.Close()
+	} else {
+		s.logger.Error("Failed to get seibattle ranking rewards", zap.Error(err))
+	}
+	if len(weeklySeibatuRankingRewards) == 0 {
+		weeklySeibatuRankingRewards = append(weeklySeibatuRankingRewards, WeeklySeibatuRankingReward{})
 	}
 	for _, reward := range weeklySeibatuRankingRewards {
 		bf := byteframe.NewByteFrame()
@@ -45,10 +66,20 @@ func handleMsgMhfGetWeeklySeibatuRankingReward(s *Session, p mhfpacket.MHFPacket
 
 func handleMsgMhfGetFixedSeibatuRankingTable(s *Session, p mhfpacket.MHFPacket) {
 	pkt := p.(*mhfpacket.MsgMhfGetFixedSeibatuRankingTable)
+	var rank, score int32
+	var name string
+	guild, err := GetGuildInfoByCharacterId(s, s.charID)
+	if err == nil && guild != nil {
+		err = s.server.db.QueryRow(`SELECT rank, score, COALESCE((SELECT name FROM guilds WHERE id = guild_id), '') FROM seibattle_results
+			WHERE guild_id = $1 ORDER BY timetable_id DESC LIMIT 1`, guild.ID).Scan(&rank, &score, &name)
+		if err != nil && err != sql.ErrNoRows {
+			s.logger.Error("Failed to get seibattle ranking", zap.Error(err))
+		}
+	}
 	bf := byteframe.NewByteFrame()
-	bf.WriteInt32(0)
-	bf.WriteInt32(0)
-	bf.WriteBytes(make([]byte, 32))
+	bf.WriteInt32(rank)
+	bf.WriteInt32(score)
+	bf.WriteBytes(stringsupport.PaddedString(name, 32, true))
 	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
 
@@ -104,3 +135,303 @@ func handleMsgMhfReadBeatLevelMyRanking(s *Session, p mhfpacket.MHFPacket) {
 	bf := byteframe.NewByteFrame()
 	doAckBufSucceed(s, pkt.AckHandle, bf.Data())
 }
+
+type SeibattleTimetable struct {
+	ID    uint32    `db:"id"`
+	Start time.Time `db:"start_time"`
+	End   time.Time `db:"end_time"`
+}
+
+type SeibattleKeyScore struct {
+	Unk0 uint8
+	Unk1 int32
+}
+
+type SeibattleCareer struct {
+	Unk0 uint16
+	Unk1 uint16
+	Unk2 uint16
+}
+
+type SeibattleOpponent struct {
+	Unk0 int32
+	Unk1 int8
+}
+
+type SeibattleConventionResult struct {
+	Unk0 uint32
+	Unk1 uint16
+	Unk2 uint16
+	Unk3 uint16
+	Unk4 uint16
+}
+
+type SeibattleCharScore struct {
+	Unk0 uint32
+}
+
+type SeibattleCurResult struct {
+	Unk0 uint32
+	Unk1 uint16
+	Unk2 uint16
+	Unk3 uint16
+}
+
+type Seibattle struct {
+	Timetable        []SeibattleTimetable
+	KeyScore         []SeibattleKeyScore
+	Career           []SeibattleCareer
+	Opponent         []SeibattleOpponent
+	ConventionResult []SeibattleConventionResult
+	CharScore        []SeibattleCharScore
+	CurResult        []SeibattleCurResult
+}
+
+// SeibattleStanding is the score of a guild in a convention.
+type SeibattleStanding struct {
+	GuildID uint32 `db:"guild_id"`
+	Name    string `db:"name"`
+	Score   int64  `db:"score"`
+}
+
+// getSeibattleTimetable returns today's conventions, which are scheduled by manageSeibattle.
+func getSeibattleTimetable(db *sqlx.DB) ([]SeibattleTimetable, error) {
+	midnight := TimeMidnight()
+	var timetable []SeibattleTimetable
+	err := db.Select(&timetable, `SELECT id, start_time, end_time FROM seibattle_timetable WHERE end_time > $1 AND start_time < $2 ORDER BY start_time`,
+		midnight, midnight.Add(24*time.Hour))
+	return timetable, err
+}
+
+// currentSeibattle returns the convention of a timetable in progress, if any.
+func currentSeibattle(timetable []SeibattleTimetable) (SeibattleTimetable, bool) {
+	now := TimeAdjusted()
+	for _, entry := range timetable {
+		if !now.Before(entry.Start) && now.Before(entry.End) {
+			return entry, true
+		}
+	}
+	return SeibattleTimetable{}, false
+}
+
+// getSeibattleStandings returns the guild scores of a convention, highest first.
+func getSeibattleStandings(db *sqlx.DB, timetableID uint32) ([]SeibattleStanding, error) {
+	var standings []SeibattleStanding
+	err := db.Select(&standings, `SELECT ss.guild_id, COALESCE(g.name, '') AS name, SUM(ss.score) AS score
+		FROM seibattle_scores ss LEFT JOIN guilds g ON g.id = ss.guild_id
+		WHERE ss.timetable_id = $1 GROUP BY ss.guild_id, g.name ORDER BY score DESC, ss.guild_id`, timetableID)
+	return standings, err
+}
+
+// seibattleOpponent pairs guilds by their standing, first against second, third against fourth and so on.
+func seibattleOpponent(standings []SeibattleStanding, guildID uint32) (SeibattleStanding, int, bool) {
+	for i, standing := range standings {
+		if standing.GuildID != guildID {
+			continue
+		}
+		j := i + 1
+		if i%2 == 1 {
+			j = i - 1
+		}
+		if j < len(standings) {
+			return standings[j], i + 1, true
+		}
+		return SeibattleStanding{}, i + 1, false
+	}
+	return SeibattleStanding{}, 0, false
+}
+
+// manageSeibattle schedules conventions and settles the ended ones until the server shuts down.
+func (s *Server) manageSeibattle() {
+	for {
+		s.Lock()
+		shutdown := s.isShuttingDown
+		s.Unlock()
+		if shutdown {
+			return
+		}
+		s.scheduleSeibattles()
+		s.settleSeibattles()
+		time.Sleep(time.Minute)
+	}
+}
+
+// scheduleSeibattles schedules three 8 hour conventions for today and tomorrow, so that
+// the timetable is in place before each day starts. Other channels may schedule the same
+// conventions concurrently.
+func (s *Server) scheduleSeibattles() {
+	midnight := TimeMidnight()
+	for i := 0; i < 6; i++ {
+		_, err := s.db.Exec(`INSERT INTO seibattle_timetable (start_time, end_time) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+			midnight.Add(time.Duration(i*8)*time.Hour), midnight.Add(time.Duration((i+1)*8)*time.Hour))
+		if err != nil {
+			s.logger.Error("Failed to schedule seibattle", zap.Error(err))
+			return
+		}
+	}
+}
+
+// settleSeibattles records the results of every ended convention that has not been settled.
+func (s *Server) settleSeibattles() {
+	var ended []uint32
+	err := s.db.Select(&ended, `SELECT id FROM seibattle_timetable t WHERE end_time <= $1
+		AND EXISTS (SELECT 1 FROM seibattle_scores ss WHERE ss.timetable_id = t.id)
+		AND NOT EXISTS (SELECT 1 FROM seibattle_results sr WHERE sr.timetable_id = t.id)`, TimeAdjusted())
+	if err != nil {
+		s.logger.Error("Failed to get unsettled seibattles", zap.Error(err))
+		return
+	}
+	for _, id := range ended {
+		standings, err := getSeibattleStandings(s.db, id)
+		if err != nil {
+			s.logger.Error("Failed to get seibattle standings", zap.Error(err))
+			return
+		}
+		for _, standing := range standings {
+			opponent, rank, _ := seibattleOpponent(standings, standing.GuildID)
+			_, err = s.db.Exec(`INSERT INTO seibattle_results (timetable_id, guild_id, opponent_id, score, opponent_score, rank)
+				VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`, id, standing.GuildID, opponent.GuildID, standing.Score, opponent.Score, rank)
+			if err != nil {
+				s.logger.Error("Failed to settle seibattle", zap.Error(err), zap.Uint32("timetableID", id))
+			}
+		}
+	}
+}
+
+func handleMsgMhfGetSeibattle(s *Session, p mhfpacket.MHFPacket) {
+	pkt := p.(*mhfpacket.MsgMhfGetSeibattle)
+	var data []*byteframe.ByteFrame
+	guildID := pkt.GuildID
+	if guildID == 0 {
+		guild, err := GetGuildInfoByCharacterId(s, s.charID)
+		if err == nil && guild != nil {
+			guildID = guild.ID
+		}
+	}
+	timetable, err := getSeibattleTimetable(s.server.db)
+	if err != nil {
+		s.logger.Error("Failed to get seibattle timetable", zap.Error(err))
+	}
+	seibattle := Seibattle{Timetable: timetable}
+
+	var standings []SeibattleStanding
+	current, inProgress := currentSeibattle(seibattle.Timetable)
+	if inProgress {
+		standings, err = getSeibattleStandings(s.server.db, current.ID)
+		if err != nil {
+			s.logger.Error("Failed to get seibattle standings", zap.Error(err))
+		}
+	}
+	var guildScore int64
+	for _, standing := range standings {
+		if standing.GuildID == guildID {
+			guildScore = standing.Score
+		}
+	}
+	opponent, rank, paired := seibattleOpponent(standings, guildID)
+
+	switch pkt.Type {
+	case 3: // Key score?
+		for i, entry := range seibattle.Timetable {
+			if entry.ID == current.ID {
+				seibattle.KeyScore = append(seibattle.KeyScore, SeibattleKeyScore{uint8(i), int32(guildScore)})
+			}
+		}
+	case 4: // Career?
+		var career SeibattleCareer
+		s.server.db.QueryRow(`SELECT COUNT(*) FILTER (WHERE score > opponent_score), COUNT(*) FILTER (WHERE score < opponent_score),
+			COUNT(*) FILTER (WHERE score = opponent_score) FROM seibattle_results WHERE guild_id = $1`, guildID).Scan(&career.Unk0, &career.Unk1, &career.Unk2)
+		seibattle.Career = append(seibattle.Career, career)
+	case 5: // Opponent?
+		if paired {
+			seibattle.Opponent = append(seibattle.Opponent, SeibattleOpponent{int32(opponent.GuildID), 1})
+		}
+	case 6: // Convention result?
+		var result SeibattleConventionResult
+		var score, opponentScore int64
+		var rank uint16
+		err := s.server.db.QueryRow(`SELECT opponent_id, score, opponent_score, rank FROM seibattle_results WHERE guild_id = $1
+			ORDER BY timetable_id DESC LIMIT 1`, guildID).Scan(&result.Unk0, &score, &opponentScore, &rank)
+		if err == nil {
+			result.Unk1 = uint16(min(score, 0xFFFF))
+			result.Unk2 = uint16(min(opponentScore, 0xFFFF))
+			result.Unk3 = rank
+			seibattle.ConventionResult = append(seibattle.ConventionResult, result)
+		} else if err != sql.ErrNoRows {
+			s.logger.Error("Failed to get seibattle result", zap.Error(err))
+		}
+	case 7: // Char score?
+		var charScore SeibattleCharScore
+		if inProgress {
+			s.server.db.QueryRow(`SELECT COALESCE(SUM(score), 0) FROM seibattle_scores WHERE timetable_id = $1 AND character_id = $2`,
+				current.ID, s.charID).Scan(&charScore.Unk0)
+		}
+		seibattle.CharScore = append(seibattle.CharScore, charScore)
+	case 8: // Cur result?
+		seibattle.CurResult = append(seibattle.CurResult, SeibattleCurResult{uint32(guildScore), uint16(rank), uint16(len(standings)), 0})
+	}
+
+	switch pkt.Type {
+	case 1:
+		for _, timetable := range seibattle.Timetable {
+			bf := byteframe.NewByteFrame()
+			bf.WriteUint32(uint32(timetable.Start.Unix()))
+			bf.WriteUint32(uint32(timetable.End.Unix()))
+			data = append(data, bf)
+		}
+	case 3: // Key score?
+		for _, keyScore := range seibattle.KeyScore {
+			bf := byteframe.NewByteFrame()
+			bf.WriteUint8(keyScore.Unk0)
+			bf.WriteInt32(keyScore.Unk1)
+			data = append(data, bf)
+		}
+	case 4: // Career?
+		for _, career := range seibattle.Career {
+			bf := byteframe.NewByteFrame()
+			bf.WriteUint16(career.Unk0)
+			bf.WriteUint16(career.Unk1)
+			bf.WriteUint16(career.Unk2)
+			data = append(data, bf)
+		}
+	case 5: // Opponent?
+		for _, opponent := range seibattle.Opponent {
+			bf := byteframe.NewByteFrame()
+			bf.WriteInt32(opponent.Unk0)
+			bf.WriteInt8(opponent.Unk1)
+			data = append(data, bf)
+		}
+	case 6: // Convention result?
+		for _, conventionResult := range seibattle.ConventionResult {
+			bf := byteframe.NewByteFrame()
+			bf.WriteUint32(conventionResult.Unk0)
+			bf.WriteUint16(conventionResult.Unk1)
+			bf.WriteUint16(conventionResult.Unk2)
+			bf.WriteUint16(conventionResult.Unk3)
+			bf.WriteUint16(conventionResult.Unk4)
+			data = append(data, bf)
+		}
+	case 7: // Char score?
+		for _, charScore := range seibattle.CharScore {
+			bf := byteframe.NewByteFrame()
+			bf.WriteUint32(charScore.Unk0)
+			data = append(data, bf)
+		}
+	case 8: // Cur result?
+		for _, curResult := range seibattle.CurResult {
+			bf := byteframe.NewByteFrame()
+			bf.WriteUint32(curResult.Unk0)
+			bf.WriteUint16(curResult.Unk1)
+			bf.WriteUint16(curResult.Unk2)
+			bf.WriteUint16(curResult.Unk3)
+			data = append(data, bf)
+		}
+	}
+	doAckEarthSucceed(s, pkt.AckHandle, data)
+}
+
+func handleMsgMhfPostSeibattle(s *Session, p mhfpacket.MHFPacket) {
+	pkt := p.(*mhfpacket.MsgMhfPostSeibattle)
+	// No capture confirms the meaning of any field, so no score is recorded until the packet is understood.
+	doAckSimpleSucceed(s, pkt.AckHandle, make([]byte, 4))
+}
@@ -64,6 +64,8 @@ func doStageTransfer(s *Session, ackHandle uint32, stageID string) {
 	// Confirm the stage entry.
 	doAckSimpleSucceed(s, ackHandle, []byte{0x00, 0x00, 0x00, 0x00})
 
+	updatePresence(s, true)
+
 	var temp mhfpacket.MHFPacket
 	newNotif := byteframe.NewByteFrame()
 
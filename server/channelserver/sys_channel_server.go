@@ -1,6 +1,7 @@
 package channelserver
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"strings"
@@ -14,6 +15,7 @@ import (
 	"erupe-ce/network/mhfpacket"
 	"erupe-ce/server/discordbot"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/jmoiron/sqlx"
 	"go.uber.org/zap"
 )
@@ -27,6 +29,7 @@ type Config struct {
 	ErupeConfig *_config.Config
 	Name        string
 	Enable      bool
+	BinFiles    *BinFiles
 }
 
 // Map key type for a user binary part.
@@ -52,6 +55,7 @@ type Server struct {
 	objectIDs      map[*Session]uint16
 	listener       net.Listener // Listener that is created when Server.Start is called.
 	isShuttingDown bool
+	isDraining     bool
 
 	stagesLock sync.RWMutex
 	stages     map[string]*Stage
@@ -78,6 +82,9 @@ type Server struct {
 	questCacheLock sync.RWMutex
 	questCacheData map[int][]byte
 	questCacheTime map[int]time.Time
+	questCachePack map[int]*QuestPack
+	binFiles       *BinFiles
+	binWatcher     *fsnotify.Watcher
 }
 
 type Raviente struct {
@@ -170,6 +177,12 @@ func NewServer(config *Config) *Server {
 		},
 		questCacheData: make(map[int][]byte),
 		questCacheTime: make(map[int]time.Time),
+		questCachePack: make(map[int]*QuestPack),
+		binFiles:       config.BinFiles,
+	}
+
+	if s.binFiles == nil {
+		s.binFiles = NewBinFiles(s.erupeConfig.BinPath)
 	}
 
 	// Mezeporta
@@ -206,9 +219,23 @@ func (s *Server) Start() error {
 	}
 	s.listener = l
 
+	s.resetPresence()
+
 	go s.acceptClients()
 	go s.manageSessions()
 	go s.invalidateSessions()
+	go s.manageMail()
+	go s.manageEarth()
+	go s.manageSeibattle()
+
+	if s.erupeConfig.WatchBinPath {
+		s.binWatcher, err = s.newBinWatcher()
+		if err != nil {
+			s.logger.Error("Failed to watch BinPath", zap.Error(err))
+		} else {
+			go s.watchBinPath(s.binWatcher)
+		}
+	}
 
 	// Start the discord bot for chat integration.
 	if s.erupeConfig.Discord.Enabled && s.discordBot != nil {
@@ -226,10 +253,81 @@ func (s *Server) Shutdown() {
 	s.Unlock()
 
 	s.listener.Close()
+	if s.binWatcher != nil {
+		s.binWatcher.Close()
+	}
 
 	close(s.acceptConns)
 }
 
+// inMaintenance reports whether the channel has been put into maintenance.
+func (s *Server) inMaintenance() bool {
+	var maintenance bool
+	s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM server_maintenance WHERE server_id=$1)`, s.ID).Scan(&maintenance)
+	return maintenance
+}
+
+// ErrDrainDeadline is reported for sessions that were not logged out before the drain deadline.
+var ErrDrainDeadline = errors.New("drain deadline exceeded")
+
+// ErrDrainUnsaved is reported for sessions whose client did not save after the shutdown warning.
+var ErrDrainUnsaved = errors.New("no save since the shutdown warning")
+
+// DrainFailure describes a session whose state could not be persisted during a drain.
+type DrainFailure struct {
+	CharID uint32
+	Name   string
+	Err    error
+}
+
+// Drain stops accepting new sessions and logs out every connected session, waiting up to the deadline.
+// Each connection is closed first so its receive loop stops handling packets, and the loop logs the
+// session out once any packet in progress is handled.
+// Asking clients to save is out of scope: no packet is known that makes the client send MsgMhfSavedata,
+// so players are only warned by chat. Sessions that have not saved since warned are reported instead,
+// as their progress since their last save is not persisted.
+// It returns the sessions that failed to persist or did not finish in time.
+func (s *Server) Drain(deadline time.Duration, warned time.Time) []DrainFailure {
+	s.Lock()
+	s.isDraining = true
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.Unlock()
+
+	done := make(chan *Session, len(sessions))
+	pending := make(map[*Session]bool)
+	for _, session := range sessions {
+		pending[session] = true
+		session.rawConn.Close()
+		go func(session *Session) {
+			<-session.recvDone
+			done <- session
+		}(session)
+	}
+
+	var failures []DrainFailure
+	timeout := time.After(deadline)
+	for len(pending) > 0 {
+		select {
+		case session := <-done:
+			delete(pending, session)
+			if session.logoutErr != nil {
+				failures = append(failures, DrainFailure{session.charID, session.Name, session.logoutErr})
+			} else if session.charID != 0 && session.lastSave.Before(warned) {
+				failures = append(failures, DrainFailure{session.charID, session.Name, ErrDrainUnsaved})
+			}
+		case <-timeout:
+			for session := range pending {
+				failures = append(failures, DrainFailure{session.charID, session.Name, ErrDrainDeadline})
+			}
+			return failures
+		}
+	}
+	return failures
+}
+
 func (s *Server) acceptClients() {
 	for {
 		conn, err := s.listener.Accept()
@@ -264,6 +362,14 @@ func (s *Server) manageSessions() {
 				}
 			}
 
+			s.Lock()
+			draining := s.isDraining
+			s.Unlock()
+			if draining {
+				newConn.Close()
+				continue
+			}
+
 			session := NewSession(s, newConn)
 
 			s.Lock()
@@ -0,0 +1,189 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvalidDistribution is returned when a new distribution fails validation.
+var ErrInvalidDistribution = errors.New("invalid distribution")
+
+const (
+	DistributionTargetCharacter = "character"
+	DistributionTargetGuild     = "guild"
+	DistributionTargetOnline    = "online"
+	DistributionTargetRank      = "rank"
+)
+
+// NewDistribution describes a distribution to create. Character, guild and online targets create one
+// distribution per recipient, while rank targets create a single distribution gated by the HR and GR ranges.
+// SR is not mapped, so distributions are not gated on it.
+type NewDistribution struct {
+	Target          string                `json:"target"`
+	TargetID        uint32                `json:"targetId"`
+	Type            uint8                 `json:"type"`
+	EventName       string                `json:"eventName"`
+	Description     string                `json:"description"`
+	Deadline        *time.Time            `json:"deadline"`
+	TimesAcceptable uint16                `json:"timesAcceptable"`
+	MinHR           *int16                `json:"minHr"`
+	MaxHR           *int16                `json:"maxHr"`
+	MinGR           *int16                `json:"minGr"`
+	MaxGR           *int16                `json:"maxGr"`
+	Items           []NewDistributionItem `json:"items"`
+}
+
+type NewDistributionItem struct {
+	ItemType uint8  `json:"itemType"`
+	ItemID   uint32 `json:"itemId"`
+	Quantity uint32 `json:"quantity"`
+}
+
+// DistributionSummary reports a distribution and how often it has been accepted.
+type DistributionSummary struct {
+	ID              uint32     `json:"id" db:"id"`
+	CharID          *uint32    `json:"charId" db:"character_id"`
+	Type            uint8      `json:"type" db:"type"`
+	EventName       string     `json:"eventName" db:"event_name"`
+	Deadline        *time.Time `json:"deadline" db:"deadline"`
+	TimesAcceptable uint16     `json:"timesAcceptable" db:"times_acceptable"`
+	Claims          int        `json:"claims" db:"claims"`
+}
+
+// DistributionClaim reports how often a character accepted a distribution.
+type DistributionClaim struct {
+	CharID uint32 `json:"charId" db:"character_id"`
+	Name   string `json:"name" db:"name"`
+	Count  int    `json:"count" db:"count"`
+}
+
+func (d *NewDistribution) validate() error {
+	if d.Type == 0 {
+		d.Type = 1
+	}
+	if d.TimesAcceptable == 0 {
+		d.TimesAcceptable = 1
+	}
+	if d.EventName == "" {
+		d.EventName = "GM Gift!"
+	}
+	if d.Description == "" {
+		d.Description = "~C05You received a gift!"
+	}
+	switch {
+	case len(d.Items) == 0 || len(d.Items) > 255:
+		return fmt.Errorf("%w: between 1 and 255 items are required", ErrInvalidDistribution)
+	case len(d.EventName) > 255:
+		return fmt.Errorf("%w: event name too long", ErrInvalidDistribution)
+	case d.Deadline != nil && !d.Deadline.After(TimeAdjusted()):
+		return fmt.Errorf("%w: deadline has passed", ErrInvalidDistribution)
+	}
+	for _, item := range d.Items {
+		if item.Quantity == 0 {
+			return fmt.Errorf("%w: item quantity must be positive", ErrInvalidDistribution)
+		}
+	}
+	ranges := [][2]*int16{{d.MinHR, d.MaxHR}, {d.MinGR, d.MaxGR}}
+	for _, r := range ranges {
+		if r[0] != nil && r[1] != nil && *r[0] >= 0 && *r[1] >= 0 && *r[0] > *r[1] {
+			return fmt.Errorf("%w: invalid rank range", ErrInvalidDistribution)
+		}
+	}
+	switch d.Target {
+	case DistributionTargetCharacter, DistributionTargetGuild:
+		if d.TargetID == 0 {
+			return fmt.Errorf("%w: missing target", ErrInvalidDistribution)
+		}
+	case DistributionTargetOnline:
+	case DistributionTargetRank:
+		if d.MinHR == nil && d.MaxHR == nil && d.MinGR == nil && d.MaxGR == nil {
+			return fmt.Errorf("%w: missing rank range", ErrInvalidDistribution)
+		}
+	default:
+		return fmt.Errorf("%w: unknown target %q", ErrInvalidDistribution, d.Target)
+	}
+	return nil
+}
+
+// CreateDistribution validates and creates a distribution, returning the number of recipients.
+// Rank targeted distributions are available to everyone within the ranges and report 0 recipients.
+func CreateDistribution(db *sqlx.DB, d NewDistribution) (int, error) {
+	if err := d.validate(); err != nil {
+		return 0, err
+	}
+	var recipients []uint32
+	var err error
+	switch d.Target {
+	case DistributionTargetCharacter:
+		err = db.Select(&recipients, `SELECT id FROM characters WHERE id = $1 AND deleted = false`, d.TargetID)
+	case DistributionTargetGuild:
+		err = db.Select(&recipients, `SELECT character_id FROM guild_characters WHERE guild_id = $1`, d.TargetID)
+	case DistributionTargetOnline:
+		err = db.Select(&recipients, `SELECT DISTINCT char_id FROM sign_sessions WHERE char_id IS NOT NULL AND server_id IS NOT NULL`)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if d.Target != DistributionTargetRank && len(recipients) == 0 {
+		return 0, fmt.Errorf("%w: no recipients", ErrInvalidDistribution)
+	}
+
+	var deadline *time.Time
+	if d.Deadline != nil {
+		utc := d.Deadline.UTC()
+		deadline = &utc
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	create := func(charID *uint32) error {
+		var id uint32
+		err := tx.QueryRow(`INSERT INTO distribution (character_id, type, deadline, event_name, description, times_acceptable,
+			min_hr, max_hr, min_gr, max_gr) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`,
+			charID, d.Type, deadline, d.EventName, d.Description, d.TimesAcceptable, d.MinHR, d.MaxHR, d.MinGR, d.MaxGR).Scan(&id)
+		if err != nil {
+			return err
+		}
+		for _, item := range d.Items {
+			_, err = tx.Exec(`INSERT INTO distribution_items (distribution_id, item_type, item_id, quantity) VALUES ($1, $2, $3, $4)`,
+				id, item.ItemType, item.ItemID, item.Quantity)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if d.Target == DistributionTargetRank {
+		err = create(nil)
+	}
+	for i := 0; i < len(recipients) && err == nil; i++ {
+		err = create(&recipients[i])
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(recipients), tx.Commit()
+}
+
+// GetDistributions returns the most recent distributions with their total claims.
+func GetDistributions(db *sqlx.DB, limit int) ([]DistributionSummary, error) {
+	summaries := make([]DistributionSummary, 0)
+	err := db.Select(&summaries, `SELECT d.id, d.character_id, d.type, d.event_name, d.deadline, d.times_acceptable,
+		(SELECT COUNT(*) FROM distributions_accepted da WHERE da.distribution_id = d.id) AS claims
+		FROM distribution d ORDER BY d.id DESC LIMIT $1`, limit)
+	return summaries, err
+}
+
+// GetDistributionClaims returns the characters that accepted a distribution.
+func GetDistributionClaims(db *sqlx.DB, distributionID uint32) ([]DistributionClaim, error) {
+	claims := make([]DistributionClaim, 0)
+	err := db.Select(&claims, `SELECT da.character_id, COALESCE(c.name, '') AS name, COUNT(*) AS count
+		FROM distributions_accepted da LEFT JOIN characters c ON c.id = da.character_id
+		WHERE da.distribution_id = $1 GROUP BY da.character_id, c.name ORDER BY da.character_id`, distributionID)
+	return claims, err
+}
@@ -0,0 +1,82 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+
+	"erupe-ce/common/stringsupport"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvalidCharacterList is returned when a friends list or blacklist change is rejected.
+var ErrInvalidCharacterList = errors.New("invalid character list")
+
+const (
+	CharacterListFriends = "friends"
+	CharacterListBlocked = "blocked"
+)
+
+// GetCharacterList returns the presence of the characters on a friends list or blacklist.
+func GetCharacterList(db *sqlx.DB, charID uint32, list string) ([]Presence, error) {
+	if list != CharacterListFriends && list != CharacterListBlocked {
+		return nil, fmt.Errorf("%w: unknown list %q", ErrInvalidCharacterList, list)
+	}
+	var csv string
+	err := db.QueryRow(fmt.Sprintf(`SELECT COALESCE(%s, '') FROM characters WHERE id = $1`, list), charID).Scan(&csv)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint32
+	for _, id := range stringsupport.CSVElems(csv) {
+		ids = append(ids, uint32(id))
+	}
+	return GetPresence(db, ids)
+}
+
+// UpdateCharacterList adds or removes characters from a friends list or blacklist.
+func UpdateCharacterList(db *sqlx.DB, charID uint32, list string, targetIDs []uint32, remove bool) error {
+	if list != CharacterListFriends && list != CharacterListBlocked {
+		return fmt.Errorf("%w: unknown list %q", ErrInvalidCharacterList, list)
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var csv string
+	err = tx.QueryRow(fmt.Sprintf(`SELECT COALESCE(%s, '') FROM characters WHERE id = $1 FOR UPDATE`, list), charID).Scan(&csv)
+	if err != nil {
+		return err
+	}
+	for _, targetID := range targetIDs {
+		if remove {
+			csv = stringsupport.CSVRemove(csv, int(targetID))
+			continue
+		}
+		if targetID == charID {
+			return fmt.Errorf("%w: cannot add yourself", ErrInvalidCharacterList)
+		}
+		var exists bool
+		err = tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM characters WHERE id = $1 AND deleted = false)`, targetID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("%w: character %d does not exist", ErrInvalidCharacterList, targetID)
+		}
+		csv = stringsupport.CSVAdd(csv, int(targetID))
+	}
+	_, err = tx.Exec(fmt.Sprintf(`UPDATE characters SET %s = $1 WHERE id = $2`, list), csv, charID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// IsBlocked reports whether charID has blacklisted otherID.
+func IsBlocked(db *sqlx.DB, charID uint32, otherID uint32) bool {
+	var csv string
+	db.QueryRow(`SELECT COALESCE(blocked, '') FROM characters WHERE id = $1`, charID).Scan(&csv)
+	return stringsupport.CSVContains(csv, int(otherID))
+}
@@ -52,6 +52,37 @@ type i18n struct {
 			enabled  string
 			disabled string
 		}
+		rollback struct {
+			error    string
+			entry    string
+			none     string
+			online   string
+			success  string
+			notFound string
+			mode     string
+			failed   string
+		}
+		gift struct {
+			success string
+			error   string
+			invalid string
+		}
+		time struct {
+			current string
+			error   string
+		}
+		friends struct {
+			none    string
+			entry   string
+			inQuest string
+		}
+		questPack struct {
+			current  string
+			none     string
+			unloaded string
+			loaded   string
+			error    string
+		}
 		ravi struct {
 			noCommand string
 			start     struct {
@@ -137,6 +168,32 @@ func getLangStrings(s *Server) i18n {
 		i.commands.ban.error = "Error in command. Format: %s <id> [length]"
 		i.commands.ban.length = " until %s"
 
+		i.commands.rollback.error = "Error in command. Format: %s <id> [save id]"
+		i.commands.rollback.entry = "%d: %s HR%d GR%d (%d bytes)"
+		i.commands.rollback.none = "No previous saves found"
+		i.commands.rollback.online = "The character must be offline to restore a save"
+		i.commands.rollback.success = "Restored save %d"
+		i.commands.rollback.notFound = "Could not find save %d"
+		i.commands.rollback.mode = "Save %d is in the layout of another client mode"
+		i.commands.rollback.failed = "Failed to restore save %d"
+
+		i.commands.gift.success = "Created gift for %d recipients"
+		i.commands.gift.error = "Error in command. Format: %s <id|guild:id|online|hr:min-max|gr:min-max> <item type> <item id> <quantity>"
+		i.commands.gift.invalid = "Invalid gift: %s"
+
+		i.commands.time.current = "Server time: %s (offset %s)"
+		i.commands.time.error = "Error in command. Format: %s [duration|reset], e.g. 36h or -2d"
+
+		i.commands.friends.none = "None of your friends are online"
+		i.commands.friends.entry = "%s: %s Channel %d%s"
+		i.commands.friends.inQuest = " (on a quest)"
+
+		i.commands.questPack.current = "Current quest pack: %s"
+		i.commands.questPack.none = "No quest pack loaded, using loose files"
+		i.commands.questPack.unloaded = "Quest pack unloaded, using loose files"
+		i.commands.questPack.loaded = "Loaded quest pack %s"
+		i.commands.questPack.error = "Failed to load quest pack: %s"
+
 		i.commands.ravi.noCommand = "ラヴィコマンドが指定されていません"
 		i.commands.ravi.start.success = "大討伐を開始します"
 		i.commands.ravi.start.error = "大討伐は既に開催されています"
@@ -202,6 +259,32 @@ func getLangStrings(s *Server) i18n {
 		i.commands.ban.error = "Error in command. Format: %s <id> [length]"
 		i.commands.ban.length = " until %s"
 
+		i.commands.rollback.error = "Error in command. Format: %s <id> [save id]"
+		i.commands.rollback.entry = "%d: %s HR%d GR%d (%d bytes)"
+		i.commands.rollback.none = "No previous saves found"
+		i.commands.rollback.online = "The character must be offline to restore a save"
+		i.commands.rollback.success = "Restored save %d"
+		i.commands.rollback.notFound = "Could not find save %d"
+		i.commands.rollback.mode = "Save %d is in the layout of another client mode"
+		i.commands.rollback.failed = "Failed to restore save %d"
+
+		i.commands.gift.success = "Created gift for %d recipients"
+		i.commands.gift.error = "Error in command. Format: %s <id|guild:id|online|hr:min-max|gr:min-max> <item type> <item id> <quantity>"
+		i.commands.gift.invalid = "Invalid gift: %s"
+
+		i.commands.time.current = "Server time: %s (offset %s)"
+		i.commands.time.error = "Error in command. Format: %s [duration|reset], e.g. 36h or -2d"
+
+		i.commands.friends.none = "None of your friends are online"
+		i.commands.friends.entry = "%s: %s Channel %d%s"
+		i.commands.friends.inQuest = " (on a quest)"
+
+		i.commands.questPack.current = "Current quest pack: %s"
+		i.commands.questPack.none = "No quest pack loaded, using loose files"
+		i.commands.questPack.unloaded = "Quest pack unloaded, using loose files"
+		i.commands.questPack.loaded = "Loaded quest pack %s"
+		i.commands.questPack.error = "Failed to load quest pack: %s"
+
 		i.commands.timer.enabled = "Quest timer enabled"
 		i.commands.timer.disabled = "Quest timer disabled"
 
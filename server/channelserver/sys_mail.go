@@ -0,0 +1,148 @@
+package channelserver
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidMail is returned when a mail broadcast fails validation.
+var ErrInvalidMail = errors.New("invalid mail")
+
+// systemMailSender is the name shown for mail without a sending character.
+const systemMailSender = "Erupe"
+
+const (
+	MailTargetCharacter = "character"
+	MailTargetGuild     = "guild"
+	MailTargetAll       = "all"
+)
+
+// MailBroadcast is system mail sent to a character, a guild or every character once SendAt has passed.
+// The subject and body, or those of the template, may contain {name} to insert the recipient's name.
+type MailBroadcast struct {
+	ID                 uint32     `json:"id" db:"id"`
+	Target             string     `json:"target" db:"target"`
+	TargetID           uint32     `json:"targetId" db:"target_id"`
+	TemplateID         *uint32    `json:"templateId" db:"template_id"`
+	Subject            string     `json:"subject" db:"subject"`
+	Body               string     `json:"body" db:"body"`
+	AttachedItemID     uint16     `json:"itemId" db:"attached_item"`
+	AttachedItemAmount uint16     `json:"itemAmount" db:"attached_item_amount"`
+	SendAt             time.Time  `json:"sendAt" db:"send_at"`
+	SentAt             *time.Time `json:"sentAt" db:"sent_at"`
+	Recipients         int        `json:"recipients" db:"recipients"`
+	Read               int        `json:"read" db:"read"`
+	Claimed            int        `json:"claimed" db:"claimed"`
+}
+
+// ScheduleMail validates and queues a mail broadcast, returning its ID.
+func ScheduleMail(db *sqlx.DB, m MailBroadcast) (uint32, error) {
+	switch m.Target {
+	case MailTargetCharacter, MailTargetGuild:
+		if m.TargetID == 0 {
+			return 0, fmt.Errorf("%w: missing target", ErrInvalidMail)
+		}
+	case MailTargetAll:
+	default:
+		return 0, fmt.Errorf("%w: unknown target %q", ErrInvalidMail, m.Target)
+	}
+	if m.TemplateID == nil && m.Subject == "" {
+		return 0, fmt.Errorf("%w: missing subject", ErrInvalidMail)
+	}
+	if (m.AttachedItemID == 0) != (m.AttachedItemAmount == 0) {
+		return 0, fmt.Errorf("%w: attachments need an item and an amount", ErrInvalidMail)
+	}
+	if m.SendAt.IsZero() {
+		m.SendAt = TimeAdjusted()
+	}
+	err := db.QueryRow(`INSERT INTO mail_broadcasts (target, target_id, template_id, subject, body, attached_item, attached_item_amount, send_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		m.Target, m.TargetID, m.TemplateID, m.Subject, m.Body, m.AttachedItemID, m.AttachedItemAmount, m.SendAt).Scan(&m.ID)
+	return m.ID, err
+}
+
+// GetMailBroadcasts returns the most recent mail broadcasts with how many recipients read and claimed them.
+func GetMailBroadcasts(db *sqlx.DB, limit int) ([]MailBroadcast, error) {
+	broadcasts := make([]MailBroadcast, 0)
+	err := db.Select(&broadcasts, `SELECT b.id, b.target, b.target_id, b.template_id, b.subject, b.body, b.attached_item,
+		b.attached_item_amount, b.send_at, b.sent_at, COUNT(m.id) AS recipients, COUNT(m.id) FILTER (WHERE m.read) AS read,
+		COUNT(m.id) FILTER (WHERE m.attached_item_received) AS claimed
+		FROM mail_broadcasts b LEFT JOIN mail m ON m.broadcast_id = b.id
+		GROUP BY b.id ORDER BY b.id DESC LIMIT $1`, limit)
+	return broadcasts, err
+}
+
+// deliverMail sends every mail broadcast that is due and notifies the recipients online on any channel.
+// Broadcasts are claimed atomically so that only one channel delivers each.
+func (s *Server) deliverMail() {
+	var due []uint32
+	now := TimeAdjusted()
+	err := s.db.Select(&due, `SELECT id FROM mail_broadcasts WHERE sent_at IS NULL AND send_at <= $1 ORDER BY id`, now)
+	if err != nil {
+		s.logger.Error("Failed to get mail broadcasts", zap.Error(err))
+		return
+	}
+	for _, id := range due {
+		tx, err := s.db.Beginx()
+		if err != nil {
+			s.logger.Error("Failed to deliver mail", zap.Error(err))
+			return
+		}
+		res, err := tx.Exec(`UPDATE mail_broadcasts SET sent_at = $2 WHERE id = $1 AND sent_at IS NULL`, id, now)
+		if err != nil {
+			tx.Rollback()
+			s.logger.Error("Failed to deliver mail", zap.Error(err), zap.Uint32("broadcastID", id))
+			continue
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// Already delivered by another channel
+			tx.Rollback()
+			continue
+		}
+		var recipients []uint32
+		err = tx.Select(&recipients, `WITH b AS (
+				SELECT mb.*, COALESCE(t.subject, mb.subject) AS t_subject, COALESCE(t.body, mb.body) AS t_body
+				FROM mail_broadcasts mb LEFT JOIN mail_templates t ON t.id = mb.template_id WHERE mb.id = $1
+			)
+			INSERT INTO mail (sender_id, recipient_id, subject, body, attached_item, attached_item_amount, is_sys_message, broadcast_id)
+			SELECT NULL, c.id, replace(b.t_subject, '{name}', c.name), replace(b.t_body, '{name}', c.name), b.attached_item, b.attached_item_amount, true, b.id
+			FROM b JOIN characters c ON c.deleted = false AND (
+				(b.target = 'character' AND c.id = b.target_id) OR
+				(b.target = 'guild' AND c.id IN (SELECT character_id FROM guild_characters WHERE guild_id = b.target_id)) OR
+				b.target = 'all')
+			RETURNING recipient_id`, id)
+		if err != nil {
+			tx.Rollback()
+			s.logger.Error("Failed to deliver mail", zap.Error(err), zap.Uint32("broadcastID", id))
+			continue
+		}
+		if err = tx.Commit(); err != nil {
+			s.logger.Error("Failed to deliver mail", zap.Error(err), zap.Uint32("broadcastID", id))
+			continue
+		}
+		s.logger.Info("Delivered mail", zap.Uint32("broadcastID", id), zap.Int("recipients", len(recipients)))
+		for _, charID := range recipients {
+			if session := s.FindSessionByCharID(charID); session != nil {
+				SendMailNotification(session, &Mail{IsSystemMessage: true}, session)
+			}
+		}
+	}
+}
+
+// manageMail delivers scheduled mail until the server shuts down.
+func (s *Server) manageMail() {
+	for {
+		s.Lock()
+		shutdown := s.isShuttingDown
+		s.Unlock()
+		if shutdown {
+			return
+		}
+		s.deliverMail()
+		time.Sleep(10 * time.Second)
+	}
+}
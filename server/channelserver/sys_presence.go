@@ -0,0 +1,99 @@
+package channelserver
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+// Presence is where a character was last seen across every world.
+type Presence struct {
+	CharID    uint32    `json:"charId" db:"id"`
+	Name      string    `json:"name" db:"name"`
+	Online    bool      `json:"online" db:"online"`
+	ServerID  *uint16   `json:"serverId" db:"server_id"`
+	World     *int      `json:"world" db:"world"`
+	WorldName *string   `json:"worldName" db:"world_name"`
+	Channel   *int      `json:"channel" db:"channel"`
+	StageID   *string   `json:"stageId" db:"stage_id"`
+	InQuest   bool      `json:"inQuest" db:"in_quest"`
+	LastSeen  time.Time `json:"lastSeen" db:"last_seen"`
+}
+
+const presenceQuery = `SELECT c.id, c.name, COALESCE(p.online, false) AS online, p.server_id, p.world, s.world_name, p.channel,
+	p.stage_id, COALESCE(p.in_quest, false) AS in_quest, COALESCE(p.last_seen, to_timestamp(COALESCE(c.last_login, 0))) AS last_seen
+	FROM characters c
+	LEFT JOIN character_presence p ON p.character_id = c.id
+	LEFT JOIN servers s ON s.server_id = p.server_id`
+
+// GetPresence returns the presence of the given characters, in the same order.
+func GetPresence(db *sqlx.DB, charIDs []uint32) ([]Presence, error) {
+	ids := make([]int64, len(charIDs))
+	for i := range charIDs {
+		ids[i] = int64(charIDs[i])
+	}
+	presence := make([]Presence, 0)
+	err := db.Select(&presence, presenceQuery+` WHERE c.id = ANY($1) AND c.deleted = false
+		ORDER BY array_position($1, c.id::bigint)`, pq.Int64Array(ids))
+	return presence, err
+}
+
+// GetOnlinePresence returns the presence of every online character.
+func GetOnlinePresence(db *sqlx.DB) ([]Presence, error) {
+	presence := make([]Presence, 0)
+	err := db.Select(&presence, presenceQuery+` WHERE p.online = true ORDER BY p.server_id, c.name`)
+	return presence, err
+}
+
+// FindPresenceByName returns the presence of the characters with the given name.
+func FindPresenceByName(db *sqlx.DB, name string) ([]Presence, error) {
+	presence := make([]Presence, 0)
+	err := db.Select(&presence, presenceQuery+` WHERE lower(c.name) = lower($1) AND c.deleted = false ORDER BY c.id`, name)
+	return presence, err
+}
+
+// worldChannel returns the 1-indexed world and channel numbers of the server.
+func (s *Server) worldChannel() (int, int) {
+	if len(s.GlobalID) != 4 {
+		return 0, 0
+	}
+	world, _ := strconv.Atoi(s.GlobalID[:2])
+	channel, _ := strconv.Atoi(s.GlobalID[2:])
+	return world, channel
+}
+
+// updatePresence records where the session's character currently is.
+func updatePresence(s *Session, online bool) {
+	if s.charID == 0 {
+		return
+	}
+	var stageID string
+	var inQuest bool
+	s.Lock()
+	if s.stage != nil {
+		stageID = s.stage.id
+		inQuest = s.stage.isQuest()
+	}
+	s.Unlock()
+	world, channel := s.server.worldChannel()
+	// A logout only applies to the server the character was last seen on, so a late logout from a
+	// previous channel does not mark a character that already moved to another channel as offline
+	_, err := s.server.db.Exec(`INSERT INTO character_presence (character_id, online, server_id, world, channel, stage_id, in_quest, last_seen)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, now())
+		ON CONFLICT (character_id) DO UPDATE SET online = $2, server_id = $3, world = $4, channel = $5, stage_id = $6,
+		in_quest = $7, last_seen = now() WHERE $2 OR character_presence.server_id = $3`, s.charID, online, s.server.ID, world, channel, stageID, inQuest)
+	if err != nil {
+		s.logger.Error("Failed to update presence", zap.Error(err), zap.Uint32("charID", s.charID))
+	}
+}
+
+// resetPresence marks characters left online on this server by an unclean shutdown as offline.
+func (s *Server) resetPresence() {
+	_, err := s.db.Exec(`UPDATE character_presence SET online = false WHERE server_id = $1 AND online = true`, s.ID)
+	if err != nil {
+		s.logger.Error("Failed to reset presence", zap.Error(err))
+	}
+}
@@ -0,0 +1,94 @@
+package channelserver
+
+import (
+	"errors"
+	"erupe-ce/common/byteframe"
+	"erupe-ce/common/jpk"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// QuestFileProblem describes a quest or scenario file that is missing from or unreadable in the BinPath.
+type QuestFileProblem struct {
+	Kind  string `json:"kind"`
+	ID    uint32 `json:"id"`
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// checkQuestFile reports whether the quest file's header points inside the file.
+func checkQuestFile(data []byte) (err error) {
+	defer func() {
+		if recover() != nil {
+			err = errors.New("malformed quest file")
+		}
+	}()
+	if jpk.IsJPK(data) {
+		if data, err = jpk.Decode(data); err != nil {
+			return err
+		}
+	}
+	if len(data) < 4 {
+		return errors.New("quest file too short")
+	}
+	bf := byteframe.NewByteFrameFromBytes(data)
+	bf.SetLE()
+	if int(bf.ReadUint32()) >= len(data) {
+		return errors.New("quest body pointer out of range")
+	}
+	return nil
+}
+
+// questFileVariants are the day and night files of each season that clients request for a quest.
+var questFileVariants = []string{"d0", "d1", "d2", "n0", "n1", "n2"}
+
+// ScanQuestFiles verifies that every quest in event_quests, in each of its day, night and seasonal
+// variants, and every scenario in scenario_counter has a readable file, returning the files that
+// do not. Files are looked up through files, the same way the channels serve them.
+func ScanQuestFiles(db *sqlx.DB, files *BinFiles) ([]QuestFileProblem, error) {
+	var problems []QuestFileProblem
+
+	var questIDs []uint32
+	err := db.Select(&questIDs, `SELECT DISTINCT quest_id FROM event_quests ORDER BY quest_id`)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range questIDs {
+		for _, variant := range questFileVariants {
+			path := fmt.Sprintf("quests/%05d%s.bin", id, variant)
+			data, err := files.Read(path)
+			if err == nil {
+				err = checkQuestFile(data)
+			}
+			if err != nil {
+				problems = append(problems, QuestFileProblem{"quest", id, path, err.Error()})
+			}
+		}
+	}
+
+	rows, err := db.Query(`SELECT DISTINCT scenario_id, category_id FROM scenario_counter ORDER BY scenario_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var mainID uint32
+		var categoryID uint8
+		if err = rows.Scan(&mainID, &categoryID); err != nil {
+			return nil, err
+		}
+		pattern := fmt.Sprintf("scenarios/%d_0_0_0_S%d_T*_C*.bin", categoryID, mainID)
+		matches := files.Glob(pattern)
+		if len(matches) == 0 {
+			problems = append(problems, QuestFileProblem{"scenario", mainID, pattern, "file not found"})
+			continue
+		}
+		for _, match := range matches {
+			if _, err = files.Read(match); err != nil {
+				problems = append(problems, QuestFileProblem{"scenario", mainID, match, err.Error()})
+			}
+		}
+	}
+	return problems, rows.Err()
+}
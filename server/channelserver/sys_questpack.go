@@ -0,0 +1,282 @@
+package channelserver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"go.uber.org/zap"
+)
+
+// ErrInvalidQuestPack is returned when an archive is not a readable quest pack.
+var ErrInvalidQuestPack = errors.New("invalid quest pack")
+
+// QuestPackManifest is read from the manifest.json at the root of a quest pack.
+type QuestPackManifest struct {
+	Name    string   `json:"name"`
+	Version string   `json:"version"`
+	Files   []string `json:"files"`
+}
+
+// QuestPack holds the quest, scenario and event files of a versioned archive, keyed by their
+// path relative to the BinPath.
+type QuestPack struct {
+	Manifest QuestPackManifest
+	files    map[string][]byte
+}
+
+func (p *QuestPack) String() string {
+	return fmt.Sprintf("%s %s (%d files)", p.Manifest.Name, p.Manifest.Version, len(p.files))
+}
+
+func packFileName(name string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "/")
+}
+
+func readZipPack(name string) (map[string][]byte, error) {
+	r, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	files := make(map[string][]byte)
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return nil, err
+		}
+		files[packFileName(f.Name)] = data
+	}
+	return files, nil
+}
+
+func readTarPack(name string) (map[string][]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".tgz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	files := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[packFileName(header.Name)] = data
+	}
+	return files, nil
+}
+
+// LoadQuestPack reads a .zip, .tar, .tar.gz or .tgz quest pack and verifies that every file
+// listed in its manifest is present.
+func LoadQuestPack(name string) (*QuestPack, error) {
+	var files map[string][]byte
+	var err error
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		files, err = readZipPack(name)
+	case strings.HasSuffix(name, ".tar"), strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		files, err = readTarPack(name)
+	default:
+		return nil, fmt.Errorf("%w: unsupported archive %s", ErrInvalidQuestPack, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuestPack, err)
+	}
+	pack := &QuestPack{files: files}
+	manifest, ok := files["manifest.json"]
+	if !ok {
+		return nil, fmt.Errorf("%w: missing manifest.json", ErrInvalidQuestPack)
+	}
+	if err = json.Unmarshal(manifest, &pack.Manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidQuestPack, err)
+	}
+	for _, file := range pack.Manifest.Files {
+		if _, ok = files[packFileName(file)]; !ok {
+			return nil, fmt.Errorf("%w: missing %s", ErrInvalidQuestPack, file)
+		}
+	}
+	return pack, nil
+}
+
+// BinFiles looks up files relative to the BinPath, preferring the loaded quest pack. A single
+// BinFiles is shared by every channel so that a pack swap applies to all of them at once.
+type BinFiles struct {
+	binPath string
+	pack    atomic.Pointer[QuestPack]
+}
+
+// NewBinFiles returns a lookup over the loose files in binPath with no quest pack loaded.
+func NewBinFiles(binPath string) *BinFiles {
+	return &BinFiles{binPath: binPath}
+}
+
+// Pack returns the loaded quest pack, or nil when only loose files are used.
+func (b *BinFiles) Pack() *QuestPack {
+	return b.pack.Load()
+}
+
+// Swap replaces the quest pack. A nil pack reverts to the loose files in the BinPath.
+func (b *BinFiles) Swap(pack *QuestPack) {
+	b.pack.Store(pack)
+}
+
+// Read reads a file relative to the BinPath, preferring the loaded quest pack.
+func (b *BinFiles) Read(name string) ([]byte, error) {
+	return b.readFrom(b.Pack(), name)
+}
+
+func (b *BinFiles) readFrom(pack *QuestPack, name string) ([]byte, error) {
+	if pack != nil {
+		if data, ok := pack.files[packFileName(name)]; ok {
+			return append([]byte(nil), data...), nil
+		}
+	}
+	return os.ReadFile(filepath.Join(b.binPath, name))
+}
+
+// Exists reports whether a file relative to the BinPath exists in the quest pack or on disk.
+func (b *BinFiles) Exists(name string) bool {
+	if pack := b.Pack(); pack != nil {
+		if _, ok := pack.files[packFileName(name)]; ok {
+			return true
+		}
+	}
+	_, err := os.Stat(filepath.Join(b.binPath, name))
+	return err == nil
+}
+
+// Glob returns the names relative to the BinPath of the files in the quest pack or on disk
+// matching pattern.
+func (b *BinFiles) Glob(pattern string) []string {
+	pattern = packFileName(pattern)
+	seen := make(map[string]bool)
+	var names []string
+	if pack := b.Pack(); pack != nil {
+		for name := range pack.files {
+			if ok, _ := path.Match(pattern, name); ok {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	matches, _ := filepath.Glob(filepath.Join(b.binPath, filepath.FromSlash(pattern)))
+	for _, match := range matches {
+		rel, err := filepath.Rel(b.binPath, match)
+		if err != nil {
+			continue
+		}
+		if name := packFileName(rel); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readBinFile reads a file relative to the BinPath, preferring the loaded quest pack.
+func (s *Server) readBinFile(name string) ([]byte, error) {
+	return s.binFiles.Read(name)
+}
+
+// binFileExists reports whether a file relative to the BinPath exists in the quest pack or on disk.
+func (s *Server) binFileExists(name string) bool {
+	return s.binFiles.Exists(name)
+}
+
+// SwapQuestPack replaces the quest pack shared by every channel. Cached quests read from the
+// previous pack are ignored from then on. A nil pack reverts to the loose files in the BinPath.
+func (s *Server) SwapQuestPack(pack *QuestPack) {
+	s.binFiles.Swap(pack)
+}
+
+// invalidateQuestCache drops a single quest from the cache.
+func (s *Server) invalidateQuestCache(questID int) {
+	s.questCacheLock.Lock()
+	delete(s.questCacheData, questID)
+	delete(s.questCacheTime, questID)
+	delete(s.questCachePack, questID)
+	s.questCacheLock.Unlock()
+}
+
+// newBinWatcher watches the BinPath and the directories holding quests, scenarios and events.
+func (s *Server) newBinWatcher() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"", "quests", "scenarios", "events"} {
+		if err = watcher.Add(filepath.Join(s.erupeConfig.BinPath, dir)); err != nil {
+			s.logger.Debug("Failed to watch directory", zap.String("dir", dir), zap.Error(err))
+		}
+	}
+	return watcher, nil
+}
+
+// watchBinPath invalidates cached quests as soon as their loose files change.
+func (s *Server) watchBinPath(watcher *fsnotify.Watcher) {
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(filepath.Dir(event.Name)) != "quests" {
+				continue
+			}
+			base := filepath.Base(event.Name)
+			if len(base) < 5 {
+				continue
+			}
+			if questID, err := strconv.Atoi(base[:5]); err == nil {
+				s.invalidateQuestCache(questID)
+				s.logger.Debug("Quest file changed", zap.String("file", base), zap.String("op", event.Op.String()))
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			s.logger.Error("BinPath watcher error", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package channelserver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestBinFilesLookup(t *testing.T) {
+	binPath := t.TempDir()
+	if err := os.Mkdir(filepath.Join(binPath, "scenarios"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"scenarios/0_0_0_0_S1_T101_C0.bin": "loose",
+		"scenarios/0_0_0_0_S1_T102_C0.bin": "loose",
+	} {
+		if err := os.WriteFile(filepath.Join(binPath, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := NewBinFiles(binPath)
+	pack := &QuestPack{files: map[string][]byte{
+		"scenarios/0_0_0_0_S1_T101_C0.bin": []byte("pack"),
+		"scenarios/0_0_0_0_S1_T103_C0.bin": []byte("pack"),
+	}}
+
+	files.Swap(pack)
+	if data, err := files.Read("scenarios/0_0_0_0_S1_T101_C0.bin"); err != nil || string(data) != "pack" {
+		t.Errorf("Read() = %q, %v, want pack file", data, err)
+	}
+	if !files.Exists("scenarios/0_0_0_0_S1_T103_C0.bin") {
+		t.Error("Exists() = false for a file only in the pack")
+	}
+	want := []string{
+		"scenarios/0_0_0_0_S1_T101_C0.bin",
+		"scenarios/0_0_0_0_S1_T102_C0.bin",
+		"scenarios/0_0_0_0_S1_T103_C0.bin",
+	}
+	if got := files.Glob("scenarios/0_0_0_0_S1_T*_C*.bin"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Glob() = %v, want %v", got, want)
+	}
+
+	files.Swap(nil)
+	if data, err := files.Read("scenarios/0_0_0_0_S1_T101_C0.bin"); err != nil || string(data) != "loose" {
+		t.Errorf("Read() after unloading = %q, %v, want loose file", data, err)
+	}
+	if files.Exists("scenarios/0_0_0_0_S1_T103_C0.bin") {
+		t.Error("Exists() = true for a file of an unloaded pack")
+	}
+}
@@ -0,0 +1,675 @@
+package channelserver
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"erupe-ce/common/bfutil"
+	"erupe-ce/common/byteframe"
+	"erupe-ce/common/mhfitem"
+	"erupe-ce/common/stringsupport"
+	"erupe-ce/common/token"
+	_config "erupe-ce/config"
+	"erupe-ce/server/channelserver/compression/nullcomp"
+
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// ErrInvalidSave is returned when imported savedata fails validation.
+var ErrInvalidSave = errors.New("invalid savedata")
+
+var saveFieldNames = map[SavePointer]string{
+	pGender:        "gender",
+	pRP:            "rp",
+	pHouseTier:     "houseTier",
+	pHouseData:     "houseData",
+	pBookshelfData: "bookshelf",
+	pGalleryData:   "gallery",
+	pToreData:      "tore",
+	pGardenData:    "garden",
+	pPlaytime:      "playtime",
+	pWeaponType:    "weaponType",
+	pWeaponID:      "weaponId",
+	pHR:            "hr",
+	pGRP:           "grp",
+	pKQF:           "kqf",
+}
+
+var saveFieldLengths = map[SavePointer]int{
+	pGender:      1,
+	pRP:          2,
+	pHouseTier:   5,
+	pHouseData:   195,
+	pGalleryData: 1748,
+	pToreData:    240,
+	pGardenData:  68,
+	pPlaytime:    4,
+	pWeaponType:  1,
+	pWeaponID:    2,
+	pHR:          2,
+	pGRP:         4,
+	pKQF:         8,
+}
+
+// saveSections are the opaque regions carried in the sections of a SaveExport.
+var saveSections = []SavePointer{pHouseTier, pHouseData, pBookshelfData, pGalleryData, pToreData, pGardenData}
+
+// isSaveSection reports whether a field name is one of the opaque save sections.
+func isSaveSection(name string) bool {
+	for _, pointer := range saveSections {
+		if saveFieldNames[pointer] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SaveField is a known region of a decompressed save.
+type SaveField struct {
+	Name   string `json:"name"`
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// SaveSchema returns the known regions of a decompressed save for a client mode, ordered by offset.
+func SaveSchema(mode _config.Mode) []SaveField {
+	pointers := getPointersForMode(mode)
+	fields := []SaveField{{Name: "name", Offset: 88, Length: 12}}
+	for pointer, offset := range pointers {
+		if pointer == lBookshelfData {
+			continue
+		}
+		length := saveFieldLengths[pointer]
+		if pointer == pBookshelfData {
+			length = pointers[lBookshelfData]
+		}
+		fields = append(fields, SaveField{Name: saveFieldNames[pointer], Offset: offset, Length: length})
+	}
+	sort.Slice(fields, func(i, j int) bool {
+		return fields[i].Offset < fields[j].Offset
+	})
+	return fields
+}
+
+// saveMinLength returns the length a decompressed save needs to contain every known region.
+func saveMinLength(mode _config.Mode) int {
+	var n int
+	for _, field := range SaveSchema(mode) {
+		if field.Offset+field.Length > n {
+			n = field.Offset + field.Length
+		}
+	}
+	return n
+}
+
+// saveLength returns the configured length of every decompressed save in a client mode's layout.
+func saveLength(mode _config.Mode) (int, bool) {
+	for _, layout := range _config.ErupeConfig.SaveLayouts {
+		if m, ok := _config.ParseMode(layout.Mode); ok && m == mode && layout.Length > 0 {
+			return layout.Length, true
+		}
+	}
+	return 0, false
+}
+
+// checkSaveLength returns an error if a decompressed save does not fit a client mode's layout.
+// Saves must be exactly the configured length of the layout. Without a configured length, a save
+// must contain every known region and be as long as the stored save it replaces, if any.
+func checkSaveLength(decompSave []byte, mode _config.Mode, storedLength int) error {
+	if length, ok := saveLength(mode); ok {
+		if len(decompSave) != length {
+			return fmt.Errorf("%d bytes does not match the %d byte %s layout", len(decompSave), length, mode.String())
+		}
+	} else if len(decompSave) < saveMinLength(mode) {
+		return fmt.Errorf("%d bytes is shorter than %d", len(decompSave), saveMinLength(mode))
+	} else if storedLength >= saveMinLength(mode) && len(decompSave) != storedLength {
+		return fmt.Errorf("%d bytes does not match the %d byte %s layout", len(decompSave), storedLength, mode.String())
+	}
+	return nil
+}
+
+// validate checks a save received from the client before it replaces the stored save.
+func (save *CharacterSaveData) validate(s *Session, storedLength int) error {
+	mode := _config.ErupeConfig.RealClientMode
+	if err := checkSaveLength(save.decompSave, mode, storedLength); err != nil {
+		return err
+	}
+	save.updateStructWithSaveData()
+	if !save.IsNewCharacter && mode >= _config.S6 {
+		if save.HR > 999 {
+			return fmt.Errorf("HR %d is out of bounds", save.HR)
+		}
+		if save.GR > 999 {
+			return fmt.Errorf("GR %d is out of bounds", save.GR)
+		}
+	}
+	// Bypass name-checker if new
+	if save.IsNewCharacter {
+		s.Name = save.Name
+	}
+	if save.Name != s.Name && mode > _config.S10 {
+		return fmt.Errorf("name %q does not match %q", save.Name, s.Name)
+	}
+	return nil
+}
+
+// SaveItemStack is an item stack in the box of a SaveExport.
+type SaveItemStack struct {
+	ItemID   uint16 `json:"itemId"`
+	Quantity uint16 `json:"quantity"`
+}
+
+// SaveCurrencies are the balances stored alongside a save.
+type SaveCurrencies struct {
+	FrontierPoints uint32 `json:"frontierPoints"`
+	PremiumCoins   uint32 `json:"premiumCoins"`
+	TrialCoins     uint32 `json:"trialCoins"`
+	NetcafePoints  uint32 `json:"netcafePoints"`
+	KouryouPoints  uint32 `json:"kouryouPoints"`
+}
+
+// unmappedSaveRegions are the regions of a save whose offsets are not known, so the export
+// does not decode them into named structures. They are listed in every export.
+var unmappedSaveRegions = []string{"inventory", "equipment", "skills", "questFlags"}
+
+// SaveExport is the typed JSON form of a character save.
+// Only the fields with a known offset are typed. The regions listed in Unmapped
+// (inventory, equipment sets, skills, quest flags) are out of scope until their offsets are
+// mapped: they are neither decoded nor editable, and are only carried inside the Savedata blob,
+// which import writes back untouched. Every typed field is written back on import.
+type SaveExport struct {
+	ClientMode string            `json:"clientMode"`
+	CharID     uint32            `json:"charId"`
+	Name       string            `json:"name"`
+	Female     bool              `json:"female"`
+	HR         uint16            `json:"hr"`
+	GRP        uint32            `json:"grp"`
+	RP         uint16            `json:"rp"`
+	Playtime   uint32            `json:"playtime"`
+	WeaponType uint8             `json:"weaponType"`
+	WeaponID   uint16            `json:"weaponId"`
+	KQF        string            `json:"kqf"`
+	Sections   map[string][]byte `json:"sections"`
+	ItemBox    []SaveItemStack   `json:"itemBox"`
+	Currencies SaveCurrencies    `json:"currencies"`
+	Schema     []SaveField       `json:"schema"`
+	Unmapped   []string          `json:"unmapped"`
+	Savedata   []byte            `json:"savedata"`
+}
+
+// ExportCharacterSave decodes the save of a character into its typed JSON form.
+func ExportCharacterSave(db *sqlx.DB, charID uint32) (*SaveExport, error) {
+	mode := _config.ErupeConfig.RealClientMode
+	saveData, err := loadCharacterSaveData(db, charID)
+	if err != nil {
+		return nil, err
+	}
+	export := &SaveExport{
+		ClientMode: mode.String(),
+		CharID:     charID,
+		Name:       saveData.Name,
+		Sections:   make(map[string][]byte),
+		Schema:     SaveSchema(mode),
+		Unmapped:   unmappedSaveRegions,
+		Savedata:   saveData.compSave,
+	}
+	if len(saveData.decompSave) >= saveMinLength(mode) {
+		export.read(mode, saveData.decompSave)
+	}
+
+	var box []byte
+	err = db.QueryRow(`SELECT item_box, COALESCE(frontier_points, 0), COALESCE(gacha_premium, 0), COALESCE(gacha_trial, 0)
+		FROM users u WHERE u.id=(SELECT c.user_id FROM characters c WHERE c.id=$1)`, charID).Scan(&box,
+		&export.Currencies.FrontierPoints, &export.Currencies.PremiumCoins, &export.Currencies.TrialCoins)
+	if err != nil {
+		return nil, err
+	}
+	export.ItemBox = decodeItemBox(box)
+	err = db.QueryRow(`SELECT COALESCE(netcafe_points, 0), COALESCE(kouryou_point, 0) FROM characters WHERE id=$1`,
+		charID).Scan(&export.Currencies.NetcafePoints, &export.Currencies.KouryouPoints)
+	if err != nil {
+		return nil, err
+	}
+	return export, nil
+}
+
+// decodeItemBox reads the stacks of a serialized item box.
+func decodeItemBox(box []byte) []SaveItemStack {
+	stacks := make([]SaveItemStack, 0)
+	if len(box) < 4 {
+		return stacks
+	}
+	bf := byteframe.NewByteFrameFromBytes(box)
+	numStacks := bf.ReadUint16()
+	bf.ReadUint16() // Unused
+	for i := 0; i < int(numStacks) && len(box) >= 4+(i+1)*12; i++ {
+		stack := mhfitem.ReadWarehouseItem(bf)
+		stacks = append(stacks, SaveItemStack{ItemID: stack.Item.ItemID, Quantity: stack.Quantity})
+	}
+	return stacks
+}
+
+// encodeItemBox serializes item box stacks, assigning each stack a new warehouse ID.
+func encodeItemBox(stacks []SaveItemStack) []byte {
+	items := make([]mhfitem.MHFItemStack, 0, len(stacks))
+	for _, stack := range stacks {
+		items = append(items, mhfitem.MHFItemStack{
+			WarehouseID: token.RNG.Uint32(),
+			Item:        mhfitem.MHFItem{ItemID: stack.ItemID},
+			Quantity:    stack.Quantity,
+		})
+	}
+	return mhfitem.SerializeWarehouseItems(items)
+}
+
+// saveField returns the region of a pointer for a client mode.
+func saveField(mode _config.Mode, pointer SavePointer) (SaveField, bool) {
+	name := saveFieldNames[pointer]
+	for _, field := range SaveSchema(mode) {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return SaveField{}, false
+}
+
+// decodeSave validates and decompresses the savedata of an export for a client mode.
+func (export *SaveExport) decodeSave(mode _config.Mode) ([]byte, error) {
+	if len(export.Savedata) == 0 {
+		return nil, fmt.Errorf("%w: no savedata", ErrInvalidSave)
+	}
+	decompSave, err := nullcomp.Decompress(export.Savedata)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSave, err)
+	}
+	if err = checkSaveLength(decompSave, mode, 0); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidSave, err)
+	}
+	return decompSave, nil
+}
+
+// read fills the typed fields of an export from a decompressed save. Fields without
+// a region in the client mode's layout are left zeroed.
+func (export *SaveExport) read(mode _config.Mode, decompSave []byte) {
+	export.Name = stringsupport.SJISToUTF8(bfutil.UpToNull(decompSave[88:100]))
+	pointers := getPointersForMode(mode)
+	export.Female = decompSave[pointers[pGender]] == 1
+	if p, ok := pointers[pHR]; ok {
+		export.HR = binary.LittleEndian.Uint16(decompSave[p:])
+	}
+	if p, ok := pointers[pGRP]; ok {
+		export.GRP = binary.LittleEndian.Uint32(decompSave[p:])
+	}
+	if p, ok := pointers[pRP]; ok {
+		export.RP = binary.LittleEndian.Uint16(decompSave[p:])
+	}
+	if p, ok := pointers[pPlaytime]; ok {
+		export.Playtime = binary.LittleEndian.Uint32(decompSave[p:])
+	}
+	if p, ok := pointers[pWeaponType]; ok {
+		export.WeaponType = decompSave[p]
+	}
+	if p, ok := pointers[pWeaponID]; ok {
+		export.WeaponID = binary.LittleEndian.Uint16(decompSave[p:])
+	}
+	if p, ok := pointers[pKQF]; ok {
+		export.KQF = hex.EncodeToString(decompSave[p : p+8])
+	}
+	if export.Sections == nil {
+		export.Sections = make(map[string][]byte)
+	}
+	for _, pointer := range saveSections {
+		if field, ok := saveField(mode, pointer); ok {
+			export.Sections[field.Name] = decompSave[field.Offset : field.Offset+field.Length]
+		}
+	}
+}
+
+// apply validates the typed fields of an export and writes them over a decompressed save.
+func (export *SaveExport) apply(mode _config.Mode, decompSave []byte) error {
+	sjisName, err := japanese.ShiftJIS.NewEncoder().String(export.Name)
+	if err != nil || len(sjisName) == 0 || len(sjisName) > 11 || strings.ContainsRune(export.Name, 0) {
+		return ErrInvalidName
+	} else if stringsupport.SJISToUTF8([]byte(sjisName)) != export.Name {
+		return ErrInvalidName
+	}
+	if export.HR > 999 {
+		return fmt.Errorf("%w: HR %d is out of bounds", ErrInvalidSave, export.HR)
+	}
+	// Sections are written first, as some overlap typed fields which take precedence
+	for name, data := range export.Sections {
+		var found bool
+		for _, pointer := range saveSections {
+			field, ok := saveField(mode, pointer)
+			if ok && field.Name == name {
+				if len(data) != field.Length {
+					return fmt.Errorf("%w: section %s must be %d bytes", ErrInvalidSave, name, field.Length)
+				}
+				copy(decompSave[field.Offset:], data)
+				found = true
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: unknown section %s", ErrInvalidSave, name)
+		}
+	}
+	copy(decompSave[88:100], make([]byte, 12))
+	copy(decompSave[88:100], sjisName)
+
+	pointers := getPointersForMode(mode)
+	if export.Female {
+		decompSave[pointers[pGender]] = 1
+	} else {
+		decompSave[pointers[pGender]] = 0
+	}
+	if p, ok := pointers[pHR]; ok {
+		binary.LittleEndian.PutUint16(decompSave[p:], export.HR)
+	}
+	if p, ok := pointers[pGRP]; ok {
+		binary.LittleEndian.PutUint32(decompSave[p:], export.GRP)
+	}
+	if p, ok := pointers[pRP]; ok {
+		binary.LittleEndian.PutUint16(decompSave[p:], export.RP)
+	}
+	if p, ok := pointers[pPlaytime]; ok {
+		binary.LittleEndian.PutUint32(decompSave[p:], export.Playtime)
+	}
+	if p, ok := pointers[pWeaponType]; ok {
+		decompSave[p] = export.WeaponType
+	}
+	if p, ok := pointers[pWeaponID]; ok {
+		binary.LittleEndian.PutUint16(decompSave[p:], export.WeaponID)
+	}
+	if p, ok := pointers[pKQF]; ok {
+		kqf, err := hex.DecodeString(export.KQF)
+		if err != nil || len(kqf) != 8 {
+			return fmt.Errorf("%w: kqf must be 8 bytes", ErrInvalidSave)
+		}
+		copy(decompSave[p:], kqf)
+	}
+	return nil
+}
+
+// ImportCharacterSave validates an export and replaces the save, item box and balances of an
+// offline character with it. The replaced save is kept in history so the import can be rolled back.
+func ImportCharacterSave(db *sqlx.DB, charID uint32, export *SaveExport) error {
+	mode := _config.ErupeConfig.RealClientMode
+	if export.ClientMode != mode.String() {
+		return fmt.Errorf("%w: exported from %s, server is %s", ErrInvalidSave, export.ClientMode, mode.String())
+	}
+	decompSave, err := export.decodeSave(mode)
+	if err != nil {
+		return err
+	}
+	err = export.apply(mode, decompSave)
+	if err != nil {
+		return err
+	}
+
+	saveData := &CharacterSaveData{
+		CharID:     charID,
+		Pointers:   getPointersForMode(mode),
+		decompSave: decompSave,
+	}
+	saveData.updateStructWithSaveData()
+	if mode >= _config.G1 {
+		err = saveData.Compress()
+		if err != nil {
+			return err
+		}
+	} else {
+		saveData.compSave = saveData.decompSave
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = archiveSave(tx, charID); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`UPDATE characters SET savedata=$1, name=$2, hr=$3, gr=$4, is_female=$5, weapon_type=$6, weapon_id=$7,
+		netcafe_points=$8, kouryou_point=$9, save_mode=$10 WHERE id=$11`, saveData.compSave, saveData.Name, saveData.HR, saveData.GR, saveData.Gender,
+		saveData.WeaponType, saveData.WeaponID, export.Currencies.NetcafePoints, export.Currencies.KouryouPoints, mode.String(), charID)
+	if err != nil {
+		return err
+	} else if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	_, err = tx.Exec(`UPDATE users u SET item_box=$1, frontier_points=$2, gacha_premium=$3, gacha_trial=$4
+		WHERE u.id=(SELECT c.user_id FROM characters c WHERE c.id=$5)`, encodeItemBox(export.ItemBox),
+		export.Currencies.FrontierPoints, export.Currencies.PremiumCoins, export.Currencies.TrialCoins, charID)
+	if err != nil {
+		return err
+	}
+	if err = pruneSaveHistory(tx, charID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SaveMigration reports how a save was converted between client modes.
+// Only known fields with a confirmed offset in both layouts are carried. Dropped lists the
+// fields with no place in the target layout and the byte ranges of the source save outside
+// any carried field, which are not copied. Defaulted fields have no value in the source layout.
+// Every target byte outside a carried field is zeroed and counted as filled.
+type SaveMigration struct {
+	CharID    uint32   `json:"charId"`
+	Name      string   `json:"name"`
+	Carried   []string `json:"carried"`
+	Dropped   []string `json:"dropped"`
+	Defaulted []string `json:"defaulted"`
+	Truncated []string `json:"truncated"`
+	Discarded int      `json:"discarded"`
+	Filled    int      `json:"filled"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// migrationSchema returns the fields of a layout that can be migrated.
+// The bookshelf pointer is only confirmed in the ZZ layout, so it is left out of the others.
+func migrationSchema(mode _config.Mode) []SaveField {
+	var fields []SaveField
+	for _, field := range SaveSchema(mode) {
+		if field.Name == saveFieldNames[pBookshelfData] && mode != _config.ZZ {
+			continue
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// checkMigration returns an error if saves cannot be migrated between two client modes.
+func checkMigration(from _config.Mode, to _config.Mode) error {
+	if from == to {
+		return fmt.Errorf("%w: saves are already in the %s layout", ErrInvalidSave, to.String())
+	}
+	for _, mode := range []_config.Mode{from, to} {
+		if _, ok := getPointersForMode(mode)[pPlaytime]; !ok {
+			return fmt.Errorf("%w: the %s save layout is not mapped", ErrInvalidSave, mode.String())
+		}
+		if _, ok := saveLength(mode); !ok {
+			return fmt.Errorf("%w: the length of the %s save layout is not configured", ErrInvalidSave, mode.String())
+		}
+	}
+	return nil
+}
+
+// migrateSave moves a decompressed save from one client mode's layout to another's.
+// The migrated save has exactly the configured length of the target layout.
+func migrateSave(decompSave []byte, from _config.Mode, to _config.Mode) ([]byte, SaveMigration, error) {
+	var report SaveMigration
+	if err := checkMigration(from, to); err != nil {
+		return nil, report, err
+	}
+	srcLength, _ := saveLength(from)
+	dstLength, _ := saveLength(to)
+	if len(decompSave) != srcLength {
+		return nil, report, fmt.Errorf("%w: %d bytes does not match the %d byte %s layout", ErrInvalidSave, len(decompSave), srcLength, from.String())
+	}
+	target := make(map[string]SaveField)
+	for _, field := range migrationSchema(to) {
+		if field.Offset+field.Length <= dstLength {
+			target[field.Name] = field
+		}
+	}
+
+	// sources holds the source offset of each target byte, or -1 where the target is zeroed
+	sources := make([]int, dstLength)
+	for i := range sources {
+		sources[i] = -1
+	}
+	// Sections are placed first, as some overlap typed fields which take precedence
+	fields := migrationSchema(from)
+	sort.SliceStable(fields, func(i, j int) bool {
+		return isSaveSection(fields[i].Name) && !isSaveSection(fields[j].Name)
+	})
+	for _, field := range fields {
+		dst, ok := target[field.Name]
+		if !ok || field.Offset+field.Length > srcLength {
+			report.Dropped = append(report.Dropped, field.Name)
+			continue
+		}
+		delete(target, field.Name)
+		length := field.Length
+		if dst.Length < length {
+			length = dst.Length
+			report.Truncated = append(report.Truncated, field.Name)
+		}
+		for i := 0; i < dst.Length; i++ {
+			sources[dst.Offset+i] = -1
+			if i < length {
+				sources[dst.Offset+i] = field.Offset + i
+			}
+		}
+		report.Carried = append(report.Carried, field.Name)
+	}
+	for _, field := range migrationSchema(to) {
+		if _, ok := target[field.Name]; ok {
+			report.Defaulted = append(report.Defaulted, field.Name)
+		}
+	}
+
+	migrated := make([]byte, dstLength)
+	used := make([]bool, srcLength)
+	for i, src := range sources {
+		if src < 0 {
+			report.Filled++
+			continue
+		}
+		migrated[i] = decompSave[src]
+		used[src] = true
+	}
+	for start := 0; start < len(used); start++ {
+		if used[start] {
+			continue
+		}
+		end := start
+		for end+1 < len(used) && !used[end+1] {
+			end++
+		}
+		report.Dropped = append(report.Dropped, fmt.Sprintf("%d-%d", start, end))
+		report.Discarded += end - start + 1
+		start = end
+	}
+	return migrated, report, nil
+}
+
+// MigrateSaves converts stored saves from one client mode's layout to another's, keeping the
+// replaced saves in history. Online characters and saves already recorded in another layout
+// are skipped. If charID is 0 every save is migrated, and with dryRun set only the report is produced.
+func MigrateSaves(db *sqlx.DB, from _config.Mode, to _config.Mode, charID uint32, dryRun bool) ([]SaveMigration, error) {
+	if err := checkMigration(from, to); err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(`SELECT id, name, savedata, COALESCE(save_mode, ''), EXISTS (SELECT 1 FROM sign_sessions ss WHERE ss.char_id=c.id)
+		FROM characters c WHERE savedata IS NOT NULL AND is_new_character=false AND ($1=0 OR id=$1) ORDER BY id`, charID)
+	if err != nil {
+		return nil, err
+	}
+	type save struct {
+		id       uint32
+		name     string
+		compSave []byte
+		mode     string
+		online   bool
+	}
+	var saves []save
+	for rows.Next() {
+		var s save
+		if err = rows.Scan(&s.id, &s.name, &s.compSave, &s.mode, &s.online); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		saves = append(saves, s)
+	}
+	rows.Close()
+
+	reports := make([]SaveMigration, 0, len(saves))
+	for _, s := range saves {
+		report := SaveMigration{CharID: s.id, Name: s.name}
+		if s.online {
+			report.Error = "character online"
+			reports = append(reports, report)
+			continue
+		} else if s.mode != "" && s.mode != from.String() {
+			report.Error = fmt.Sprintf("save is in the %s layout", s.mode)
+			reports = append(reports, report)
+			continue
+		}
+		decompSave, err := nullcomp.Decompress(s.compSave)
+		if err != nil {
+			report.Error = err.Error()
+			reports = append(reports, report)
+			continue
+		}
+		migrated, migration, err := migrateSave(decompSave, from, to)
+		if err != nil {
+			report.Error = err.Error()
+			reports = append(reports, report)
+			continue
+		}
+		migration.CharID, migration.Name = s.id, s.name
+		if !dryRun {
+			if err = storeMigratedSave(db, s.id, migrated, to); err != nil {
+				migration.Error = err.Error()
+			}
+		}
+		reports = append(reports, migration)
+	}
+	return reports, nil
+}
+
+// storeMigratedSave replaces the save of a character with a migrated save, keeping the replaced save in history.
+func storeMigratedSave(db *sqlx.DB, charID uint32, decompSave []byte, mode _config.Mode) error {
+	compSave := decompSave
+	if mode >= _config.G1 {
+		var err error
+		compSave, err = nullcomp.Compress(decompSave)
+		if err != nil {
+			return err
+		}
+	}
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err = archiveSave(tx, charID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`UPDATE characters SET savedata=$1, save_mode=$2 WHERE id=$3`, compSave, mode.String(), charID)
+	if err != nil {
+		return err
+	}
+	if err = pruneSaveHistory(tx, charID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
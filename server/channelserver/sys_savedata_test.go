@@ -0,0 +1,282 @@
+package channelserver
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	_config "erupe-ce/config"
+	"erupe-ce/server/channelserver/compression/nullcomp"
+)
+
+func testExport(mode _config.Mode) *SaveExport {
+	export := &SaveExport{
+		ClientMode: mode.String(),
+		Name:       "Hunter",
+		Female:     true,
+		Sections:   make(map[string][]byte),
+	}
+	pointers := getPointersForMode(mode)
+	if _, ok := pointers[pHR]; ok {
+		export.HR = 999
+		export.Playtime = 123456
+		export.WeaponType = 7
+		export.WeaponID = 0x1234
+		export.RP = 300
+	}
+	if _, ok := pointers[pGRP]; ok {
+		export.GRP = 1400900
+	}
+	if _, ok := pointers[pKQF]; ok {
+		export.KQF = "0102030405060708"
+	}
+	for i, pointer := range saveSections {
+		if field, ok := saveField(mode, pointer); ok {
+			data := make([]byte, field.Length)
+			for j := range data {
+				data[j] = byte(i + j)
+			}
+			export.Sections[field.Name] = data
+		}
+	}
+	return export
+}
+
+func TestSaveExportRoundTrip(t *testing.T) {
+	for _, mode := range []_config.Mode{_config.S6, _config.F5, _config.G1, _config.G10, _config.ZZ} {
+		want := testExport(mode)
+		decompSave := make([]byte, saveMinLength(mode)+64)
+		if err := want.apply(mode, decompSave); err != nil {
+			t.Fatalf("%s: apply() error = %v", mode, err)
+		}
+		compSave, err := nullcomp.Compress(decompSave)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := &SaveExport{ClientMode: mode.String(), Savedata: compSave}
+		decoded, err := got.decodeSave(mode)
+		if err != nil {
+			t.Fatalf("%s: decodeSave() error = %v", mode, err)
+		}
+		got.read(mode, decoded)
+
+		// Sections overlap some typed fields, so only the typed fields are compared directly
+		typed := *got
+		typed.Sections, typed.Savedata, want.Sections = nil, nil, nil
+		if !reflect.DeepEqual(&typed, want) {
+			t.Errorf("%s: read(apply()) = %+v, want %+v", mode, typed, *want)
+		}
+
+		// Importing an export over any save reproduces the exported save
+		reimported := make([]byte, len(decompSave))
+		if err = got.apply(mode, reimported); err != nil {
+			t.Fatalf("%s: apply(read()) error = %v", mode, err)
+		}
+		if !reflect.DeepEqual(reimported, decompSave) {
+			t.Errorf("%s: apply(read()) does not reproduce the exported save", mode)
+		}
+	}
+}
+
+func TestSaveExportApplyInvalid(t *testing.T) {
+	mode := _config.ZZ
+	tests := []struct {
+		name   string
+		modify func(*SaveExport)
+		want   error
+	}{
+		{"empty name", func(e *SaveExport) { e.Name = "" }, ErrInvalidName},
+		{"long name", func(e *SaveExport) { e.Name = "HunterHunter" }, ErrInvalidName},
+		{"hr", func(e *SaveExport) { e.HR = 1000 }, ErrInvalidSave},
+		{"kqf", func(e *SaveExport) { e.KQF = "0102" }, ErrInvalidSave},
+		{"section length", func(e *SaveExport) { e.Sections["garden"] = []byte{1} }, ErrInvalidSave},
+		{"unknown section", func(e *SaveExport) { e.Sections["inventory"] = []byte{1} }, ErrInvalidSave},
+	}
+	for _, tt := range tests {
+		export := testExport(mode)
+		tt.modify(export)
+		err := export.apply(mode, make([]byte, saveMinLength(mode)))
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: apply() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSaveExportDecodeShort(t *testing.T) {
+	compSave, err := nullcomp.Compress(make([]byte, 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := &SaveExport{Savedata: compSave}
+	if _, err = export.decodeSave(_config.ZZ); !errors.Is(err, ErrInvalidSave) {
+		t.Errorf("decodeSave() error = %v, want %v", err, ErrInvalidSave)
+	}
+}
+
+func TestItemBoxRoundTrip(t *testing.T) {
+	tests := [][]SaveItemStack{
+		{},
+		{{ItemID: 1, Quantity: 99}},
+		{{ItemID: 7, Quantity: 1}, {ItemID: 0xFFFF, Quantity: 0xFFFF}, {ItemID: 300, Quantity: 20}},
+	}
+	for _, want := range tests {
+		got := decodeItemBox(encodeItemBox(want))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("decodeItemBox(encodeItemBox(%v)) = %v", want, got)
+		}
+	}
+	if got := decodeItemBox(nil); len(got) != 0 {
+		t.Errorf("decodeItemBox(nil) = %v, want empty", got)
+	}
+}
+
+// withSaveLayouts configures a save length for each client mode used by the tests.
+func withSaveLayouts(t *testing.T, modes ..._config.Mode) {
+	t.Helper()
+	layouts := _config.ErupeConfig.SaveLayouts
+	t.Cleanup(func() { _config.ErupeConfig.SaveLayouts = layouts })
+	_config.ErupeConfig.SaveLayouts = nil
+	for _, mode := range modes {
+		_config.ErupeConfig.SaveLayouts = append(_config.ErupeConfig.SaveLayouts,
+			_config.SaveLayout{Mode: mode.String(), Length: saveMinLength(mode) + 32})
+	}
+}
+
+func TestMigrateSave(t *testing.T) {
+	withSaveLayouts(t, _config.S6, _config.F5, _config.G1, _config.G10, _config.ZZ)
+	tests := []struct {
+		from, to  _config.Mode
+		dropped   []string
+		defaulted []string
+		truncated []string
+	}{
+		{_config.G10, _config.ZZ, nil, []string{"bookshelf"}, nil},
+		{_config.ZZ, _config.G10, []string{"bookshelf"}, nil, nil},
+		{_config.F5, _config.ZZ, nil, []string{"grp", "bookshelf", "kqf"}, nil},
+		{_config.ZZ, _config.F5, []string{"bookshelf", "grp", "kqf"}, nil, nil},
+		{_config.S6, _config.G1, nil, []string{"grp", "kqf"}, nil},
+	}
+	for _, tt := range tests {
+		srcLength, _ := saveLength(tt.from)
+		dstLength, _ := saveLength(tt.to)
+		decompSave := make([]byte, srcLength)
+		for i := range decompSave {
+			decompSave[i] = byte(i%251) + 1
+		}
+		export := testExport(tt.from)
+		export.Sections = nil
+		if err := export.apply(tt.from, decompSave); err != nil {
+			t.Fatalf("%s to %s: apply() error = %v", tt.from, tt.to, err)
+		}
+
+		migrated, report, err := migrateSave(decompSave, tt.from, tt.to)
+		if err != nil {
+			t.Fatalf("%s to %s: migrateSave() error = %v", tt.from, tt.to, err)
+		}
+		if len(migrated) != dstLength {
+			t.Errorf("%s to %s: migrated %d bytes, want %d", tt.from, tt.to, len(migrated), dstLength)
+		}
+		var dropped []string
+		var ranges int
+		for _, name := range report.Dropped {
+			if strings.Contains(name, "-") {
+				ranges++
+			} else {
+				dropped = append(dropped, name)
+			}
+		}
+		if !reflect.DeepEqual(dropped, tt.dropped) || !reflect.DeepEqual(report.Defaulted, tt.defaulted) ||
+			!reflect.DeepEqual(report.Truncated, tt.truncated) {
+			t.Errorf("%s to %s: dropped %v, defaulted %v, truncated %v, want %v, %v, %v", tt.from, tt.to,
+				dropped, report.Defaulted, report.Truncated, tt.dropped, tt.defaulted, tt.truncated)
+		}
+		if ranges == 0 || report.Discarded == 0 {
+			t.Errorf("%s to %s: unmapped regions not reported as dropped", tt.from, tt.to)
+		}
+
+		got := &SaveExport{ClientMode: tt.to.String()}
+		got.read(tt.to, migrated)
+		if got.Name != export.Name || got.Female != export.Female || got.HR != export.HR || got.RP != export.RP ||
+			got.Playtime != export.Playtime || got.WeaponType != export.WeaponType || got.WeaponID != export.WeaponID {
+			t.Errorf("%s to %s: migrated fields %+v, want %+v", tt.from, tt.to, got, export)
+		}
+		if _, ok := getPointersForMode(tt.from)[pKQF]; ok && got.KQF != export.KQF && len(tt.dropped) == 0 {
+			t.Errorf("%s to %s: migrated kqf %s, want %s", tt.from, tt.to, got.KQF, export.KQF)
+		}
+
+		// Unmapped regions are zeroed rather than carried
+		if migrated[10] != 0 || migrated[dstLength-1] != 0 {
+			t.Errorf("%s to %s: unmapped bytes = %d, %d, want 0", tt.from, tt.to, migrated[10], migrated[dstLength-1])
+		}
+	}
+}
+
+func TestMigrateSaveInvalid(t *testing.T) {
+	withSaveLayouts(t, _config.G10, _config.ZZ)
+	zzLength, _ := saveLength(_config.ZZ)
+	g10Length, _ := saveLength(_config.G10)
+	tests := []struct {
+		name     string
+		from, to _config.Mode
+		length   int
+	}{
+		{"same mode", _config.ZZ, _config.ZZ, zzLength},
+		{"unmapped source", _config.S7, _config.ZZ, 200000},
+		{"unmapped target", _config.ZZ, _config.F3, zzLength},
+		{"unconfigured length", _config.ZZ, _config.G1, zzLength},
+		{"short save", _config.G10, _config.ZZ, g10Length - 1},
+		{"long save", _config.G10, _config.ZZ, g10Length + 1},
+	}
+	for _, tt := range tests {
+		if _, _, err := migrateSave(make([]byte, tt.length), tt.from, tt.to); !errors.Is(err, ErrInvalidSave) {
+			t.Errorf("%s: migrateSave() error = %v, want %v", tt.name, err, ErrInvalidSave)
+		}
+	}
+}
+
+func TestSaveValidateLength(t *testing.T) {
+	mode := _config.ErupeConfig.RealClientMode
+	minLength := saveMinLength(mode)
+	tests := []struct {
+		length       int
+		storedLength int
+		valid        bool
+	}{
+		{minLength, 0, true},
+		{minLength - 1, 0, false},
+		{minLength + 100, minLength + 100, true},
+		{minLength + 99, minLength + 100, false},
+		{minLength + 101, minLength + 100, false},
+	}
+	for _, tt := range tests {
+		decompSave := make([]byte, tt.length)
+		if tt.length >= minLength {
+			if err := testExport(mode).apply(mode, decompSave); err != nil {
+				t.Fatal(err)
+			}
+		}
+		save := &CharacterSaveData{Pointers: getPointersForMode(mode), decompSave: decompSave}
+		err := save.validate(&Session{Name: "Hunter"}, tt.storedLength)
+		if (err == nil) != tt.valid {
+			t.Errorf("validate() of %d bytes over %d bytes: error = %v, want valid %t", tt.length, tt.storedLength, err, tt.valid)
+		}
+	}
+}
+
+func TestSaveValidateConfiguredLength(t *testing.T) {
+	mode := _config.ErupeConfig.RealClientMode
+	withSaveLayouts(t, mode)
+	length, _ := saveLength(mode)
+	for _, n := range []int{length - 1, length, length + 1} {
+		decompSave := make([]byte, n)
+		if err := testExport(mode).apply(mode, decompSave); err != nil {
+			t.Fatal(err)
+		}
+		save := &CharacterSaveData{Pointers: getPointersForMode(mode), decompSave: decompSave}
+		err := save.validate(&Session{Name: "Hunter"}, n)
+		if (err == nil) != (n == length) {
+			t.Errorf("validate() of %d bytes in a %d byte layout: error = %v", n, length, err)
+		}
+	}
+}
@@ -50,8 +50,10 @@ type Session struct {
 	kqf              []byte
 	kqfOverride      bool
 
-	playtime     uint32
-	playtimeTime time.Time
+	playtime        uint32
+	playtimeTime    time.Time
+	saveQuarantined bool // Diff saves are refused until a full save replaces a quarantined save
+	lastSave        time.Time
 
 	semaphore     *Semaphore // Required for the stateful MsgSysUnreserveStage packet.
 	semaphoreMode bool
@@ -67,6 +69,10 @@ type Session struct {
 	// Contains the mail list that maps accumulated indexes to mail IDs
 	mailList []int
 
+	logoutOnce sync.Once
+	logoutErr  error
+	recvDone   chan struct{} // Closed once the receive loop has exited and logged the session out
+
 	// For Debuging
 	Name     string
 	closed   bool
@@ -87,6 +93,7 @@ func NewSession(server *Server, conn net.Conn) *Session {
 		stageMoveStack: stringstack.New(),
 		ackStart:       make(map[uint32]time.Time),
 		semaphoreID:    make([]uint16, 2),
+		recvDone:       make(chan struct{}),
 	}
 	s.SetObjectID()
 	return s
@@ -177,6 +184,7 @@ func (s *Session) sendLoop() {
 }
 
 func (s *Session) recvLoop() {
+	defer close(s.recvDone)
 	for {
 		if s.closed {
 			logoutPlayer(s)
@@ -1,26 +1,24 @@
 package channelserver
 
 import (
+	"erupe-ce/common/clock"
 	"time"
 )
 
+// ServerClock is the source of the game time. It can be shifted or given a fake base to test
+// daily and weekly rollovers without changing the host clock.
+var ServerClock = clock.NewAdjustable(clock.System{}, time.FixedZone("UTC+9", 9*60*60))
+
 func TimeAdjusted() time.Time {
-	baseTime := time.Now().In(time.FixedZone("UTC+9", 9*60*60))
-	return time.Date(baseTime.Year(), baseTime.Month(), baseTime.Day(), baseTime.Hour(), baseTime.Minute(), baseTime.Second(), baseTime.Nanosecond(), baseTime.Location())
+	return ServerClock.Now()
 }
 
 func TimeMidnight() time.Time {
-	baseTime := time.Now().In(time.FixedZone("UTC+9", 9*60*60))
-	return time.Date(baseTime.Year(), baseTime.Month(), baseTime.Day(), 0, 0, 0, 0, baseTime.Location())
+	return clock.Midnight(ServerClock.Now())
 }
 
 func TimeWeekStart() time.Time {
-	midnight := TimeMidnight()
-	offset := int(midnight.Weekday()) - int(time.Monday)
-	if offset < 0 {
-		offset += 7
-	}
-	return midnight.Add(-time.Duration(offset) * 24 * time.Hour)
+	return clock.WeekStart(ServerClock.Now())
 }
 
 func TimeWeekNext() time.Time {
@@ -33,6 +33,18 @@ var Commands = []*discordgo.ApplicationCommand{
 			},
 		},
 	},
+	{
+		Name:        "where",
+		Description: "Find out where a character is online",
+		Options: []*discordgo.ApplicationCommandOption{
+			{
+				Type:        discordgo.ApplicationCommandOptionString,
+				Name:        "name",
+				Description: "The name of the character",
+				Required:    true,
+			},
+		},
+	},
 }
 
 type DiscordBot struct {
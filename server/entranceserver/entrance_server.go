@@ -22,6 +22,7 @@ type Server struct {
 	db             *sqlx.DB
 	listener       net.Listener
 	isShuttingDown bool
+	isDraining     bool
 }
 
 // Config struct allows configuring the server.
@@ -56,6 +57,13 @@ func (s *Server) Start() error {
 	return nil
 }
 
+// Drain stops the server from accepting new logins ahead of a shutdown.
+func (s *Server) Drain() {
+	s.Lock()
+	s.isDraining = true
+	s.Unlock()
+}
+
 // Shutdown exits the server gracefully.
 func (s *Server) Shutdown() {
 	s.logger.Debug("Shutting down...")
@@ -92,6 +100,12 @@ func (s *Server) acceptClients() {
 
 func (s *Server) handleEntranceServerConnection(conn net.Conn) {
 	defer conn.Close()
+	s.Lock()
+	draining := s.isDraining
+	s.Unlock()
+	if draining {
+		return
+	}
 	// Client initalizes the connection with a one-time buffer of 8 NULL bytes.
 	nullInit := make([]byte, 8)
 	n, err := io.ReadFull(conn, nullInit)
@@ -12,11 +12,45 @@ import (
 	"erupe-ce/server/channelserver"
 )
 
-func encodeServerInfo(config *_config.Config, s *Server, local bool) []byte {
+// getMaintenance returns the IDs of the channels that are in maintenance.
+func (s *Server) getMaintenance() map[uint16]bool {
+	maintenance := make(map[uint16]bool)
+	rows, err := s.db.Query("SELECT server_id FROM server_maintenance")
+	if err != nil {
+		return maintenance
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sid uint16
+		if rows.Scan(&sid) == nil {
+			maintenance[sid] = true
+		}
+	}
+	return maintenance
+}
+
+// worldInMaintenance reports whether every channel of a world is in maintenance.
+func worldInMaintenance(serverIdx int, si _config.EntranceServerInfo, maintenance map[uint16]bool) bool {
+	if len(si.Channels) == 0 {
+		return false
+	}
+	for channelIdx := range si.Channels {
+		if !maintenance[uint16((serverIdx<<8|4096)+(channelIdx|16))] {
+			return false
+		}
+	}
+	return true
+}
+
+func encodeServerInfo(config *_config.Config, s *Server, local bool, maintenance map[uint16]bool) []byte {
 	serverInfos := config.Entrance.Entries
 	bf := byteframe.NewByteFrame()
 
 	for serverIdx, si := range serverInfos {
+		// Hide Worlds that are entirely in maintenance
+		if worldInMaintenance(serverIdx, si, maintenance) {
+			continue
+		}
 		// Prevent MezFes Worlds displaying on Z1
 		if config.RealClientMode <= _config.Z1 {
 			if si.Type == 6 {
@@ -72,6 +106,10 @@ func encodeServerInfo(config *_config.Config, s *Server, local bool) []byte {
 			bf.WriteUint16(ci.MaxPlayers)
 			var currentPlayers uint16
 			s.db.QueryRow("SELECT current_players FROM servers WHERE server_id=$1", sid).Scan(&currentPlayers)
+			// Channels in maintenance are shown as full so new logins go to healthy channels
+			if maintenance[uint16(sid)] {
+				currentPlayers = ci.MaxPlayers
+			}
 			bf.WriteUint16(currentPlayers)
 			bf.WriteUint16(0)
 			bf.WriteUint16(0)
@@ -128,7 +166,18 @@ func makeSv2Resp(config *_config.Config, s *Server, local bool) []byte {
 			}
 		}
 	}
-	rawServerData := encodeServerInfo(config, s, local)
+	// and Worlds in maintenance
+	maintenance := s.getMaintenance()
+	var mt int
+	for serverIdx, si := range serverInfos {
+		if worldInMaintenance(serverIdx, si, maintenance) {
+			if (config.RealClientMode <= _config.Z1 && si.Type == 6) || (config.RealClientMode <= _config.G6 && si.Type == 5) {
+				continue
+			}
+			mt++
+		}
+	}
+	rawServerData := encodeServerInfo(config, s, local, maintenance)
 
 	if s.erupeConfig.DebugOptions.LogOutboundMessages {
 		fmt.Printf("[Server] -> [Client]\nData [%d bytes]:\n%s\n", len(rawServerData), hex.Dump(rawServerData))
@@ -140,7 +189,7 @@ func makeSv2Resp(config *_config.Config, s *Server, local bool) []byte {
 	}
 
 	bf := byteframe.NewByteFrame()
-	bf.WriteBytes(makeHeader(rawServerData, respType, uint16(len(serverInfos)-(mf+ret)), 0x00))
+	bf.WriteBytes(makeHeader(rawServerData, respType, uint16(len(serverInfos)-(mf+ret+mt)), 0x00))
 	return bf.Data()
 }
 
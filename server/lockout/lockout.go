@@ -0,0 +1,54 @@
+// Package lockout throttles failed logins, shared by the sign server and the API so both enforce the same policy.
+package lockout
+
+import (
+	"context"
+	"time"
+
+	_config "erupe-ce/config"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Duration returns how long a lock lasts after the given number of failures.
+func Duration(failures int, limit int, baseDelay int, maxDelay int) time.Duration {
+	if limit <= 0 || failures < limit {
+		return 0
+	}
+	delay := time.Duration(baseDelay) * time.Second
+	for i := limit; i < failures && delay < time.Duration(maxDelay)*time.Second; i++ {
+		delay *= 2
+	}
+	if delay > time.Duration(maxDelay)*time.Second {
+		delay = time.Duration(maxDelay) * time.Second
+	}
+	return delay
+}
+
+// Locked reports whether logins for the username or from the IP address are currently locked.
+func Locked(ctx context.Context, db *sqlx.DB, opts _config.LoginLockoutOptions, username string, ip string) bool {
+	if !opts.Enabled {
+		return false
+	}
+	since := time.Now().Add(-time.Duration(opts.Window) * time.Second)
+	var failures int
+	var lastFailure time.Time
+	err := db.QueryRowContext(ctx, `SELECT count(*), COALESCE(max(attempted_at), now()) FROM login_attempts
+		WHERE username=$1 AND success=false AND locked=false AND attempted_at > $2 AND attempted_at >
+		COALESCE((SELECT max(attempted_at) FROM login_attempts WHERE username=$1 AND success=true), 'epoch')`, username, since).Scan(&failures, &lastFailure)
+	if err == nil && time.Now().Before(lastFailure.Add(Duration(failures, opts.MaxAttempts, opts.BaseDelay, opts.MaxDelay))) {
+		return true
+	}
+	err = db.QueryRowContext(ctx, `SELECT count(*), COALESCE(max(attempted_at), now()) FROM login_attempts
+		WHERE ip=$1 AND success=false AND locked=false AND attempted_at > $2`, ip, since).Scan(&failures, &lastFailure)
+	if err == nil && time.Now().Before(lastFailure.Add(Duration(failures, opts.MaxIPAttempts, opts.BaseDelay, opts.MaxDelay))) {
+		return true
+	}
+	return false
+}
+
+// Record stores a login attempt, uid may be 0 when the user is unknown.
+func Record(ctx context.Context, db *sqlx.DB, uid uint32, username string, ip string, success bool, locked bool) error {
+	_, err := db.ExecContext(ctx, `INSERT INTO login_attempts (user_id, username, ip, success, locked) VALUES (NULLIF($1, 0), $2, $3, $4, $5)`, uid, username, ip, success, locked)
+	return err
+}
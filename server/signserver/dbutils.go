@@ -1,10 +1,12 @@
 package signserver
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"erupe-ce/common/mhfcourse"
 	"erupe-ce/common/token"
+	"erupe-ce/server/lockout"
 	"strings"
 	"time"
 
@@ -13,8 +15,19 @@ import (
 )
 
 func (s *Server) newUserChara(uid uint32) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the account so that restores and transfers see the new character in their slot check
+	if _, err = tx.Exec("SELECT id FROM users WHERE id = $1 FOR UPDATE", uid); err != nil {
+		return err
+	}
+
 	var numNewChars int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM characters WHERE user_id = $1 AND is_new_character = true", uid).Scan(&numNewChars)
+	err = tx.QueryRow("SELECT COUNT(*) FROM characters WHERE user_id = $1 AND is_new_character = true", uid).Scan(&numNewChars)
 	if err != nil {
 		return err
 	}
@@ -24,7 +37,7 @@ func (s *Server) newUserChara(uid uint32) error {
 		return err
 	}
 
-	_, err = s.db.Exec(`
+	_, err = tx.Exec(`
 		INSERT INTO characters (
 			user_id, is_female, is_new_character, name, unk_desc_string,
 			hr, gr, weapon_type, last_login)
@@ -36,7 +49,7 @@ func (s *Server) newUserChara(uid uint32) error {
 		return err
 	}
 
-	return nil
+	return tx.Commit()
 }
 
 func (s *Server) registerDBAccount(username string, password string) (uint32, error) {
@@ -184,47 +197,85 @@ func (s *Server) deleteCharacter(cid int, token string, tokenID uint32) error {
 	return nil
 }
 
-// Unused
-func (s *Server) checkToken(uid uint32) (bool, error) {
-	var exists int
-	err := s.db.QueryRow("SELECT count(*) FROM sign_sessions WHERE user_id = $1", uid).Scan(&exists)
-	if err != nil {
-		return false, err
+// tokenExpiry returns the expiry of a newly issued token, nil if tokens don't expire.
+func (s *Server) tokenExpiry() *time.Time {
+	if s.erupeConfig.Sign.TokenExpiry <= 0 {
+		return nil
 	}
-	if exists > 0 {
-		return true, nil
-	}
-	return false, nil
+	expiry := time.Now().Add(time.Duration(s.erupeConfig.Sign.TokenExpiry) * time.Second)
+	return &expiry
 }
 
 func (s *Server) registerUidToken(uid uint32) (uint32, string, error) {
 	_token := token.Generate(16)
 	var tid uint32
-	err := s.db.QueryRow(`INSERT INTO sign_sessions (user_id, token) VALUES ($1, $2) RETURNING id`, uid, _token).Scan(&tid)
+	err := s.db.QueryRow(`INSERT INTO sign_sessions (user_id, token, expires) VALUES ($1, $2, $3) RETURNING id`, uid, _token, s.tokenExpiry()).Scan(&tid)
 	return tid, _token, err
 }
 
 func (s *Server) registerPsnToken(psn string) (uint32, string, error) {
 	_token := token.Generate(16)
 	var tid uint32
-	err := s.db.QueryRow(`INSERT INTO sign_sessions (psn_id, token) VALUES ($1, $2) RETURNING id`, psn, _token).Scan(&tid)
+	err := s.db.QueryRow(`INSERT INTO sign_sessions (psn_id, token, expires) VALUES ($1, $2, $3) RETURNING id`, psn, _token, s.tokenExpiry()).Scan(&tid)
 	return tid, _token, err
 }
 
 func (s *Server) validateToken(token string, tokenID uint32) bool {
-	query := `SELECT count(*) FROM sign_sessions WHERE token = $1`
-	if tokenID > 0 {
-		query += ` AND id = $2`
-	}
 	var exists int
-	err := s.db.QueryRow(query, token, tokenID).Scan(&exists)
+	err := s.db.QueryRow(`SELECT count(*) FROM sign_sessions WHERE token = $1 AND ($2 = 0 OR id = $2) AND (expires IS NULL OR expires > now())`, token, tokenID).Scan(&exists)
 	if err != nil || exists == 0 {
 		return false
 	}
 	return true
 }
 
-func (s *Server) validateLogin(user string, pass string) (uint32, RespID) {
+// cleanupTokens deletes expired tokens that aren't attached to a channel session.
+func (s *Server) cleanupTokens() {
+	for {
+		s.Lock()
+		shutdown := s.isShuttingDown
+		s.Unlock()
+		if shutdown {
+			break
+		}
+		result, err := s.db.Exec(`DELETE FROM sign_sessions WHERE expires < now() AND server_id IS NULL`)
+		if err != nil {
+			s.logger.Error("Failed to clean up expired tokens", zap.Error(err))
+		} else if n, _ := result.RowsAffected(); n > 0 {
+			s.logger.Info("Cleaned up expired tokens", zap.Int64("count", n))
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+func (s *Server) loginLocked(user string, ip string) bool {
+	return lockout.Locked(context.Background(), s.db, s.erupeConfig.LoginLockout, user, ip)
+}
+
+func (s *Server) recordLoginAttempt(uid uint32, user string, ip string, success bool, locked bool) {
+	if err := lockout.Record(context.Background(), s.db, uid, user, ip, success, locked); err != nil {
+		s.logger.Error("Failed to record login attempt", zap.Error(err))
+	}
+}
+
+// needsSecondFactor reports whether the account is an operator protected by two-factor authentication.
+// The game client cannot provide a second factor, so these accounts may only sign in through the API.
+func (s *Server) needsSecondFactor(uid uint32) bool {
+	var op, totpEnabled bool
+	err := s.db.QueryRow(`SELECT COALESCE(op, false), totp_enabled FROM users WHERE id = $1`, uid).Scan(&op, &totpEnabled)
+	if err != nil {
+		s.logger.Error("Failed to check operator two-factor authentication", zap.Error(err))
+		return true
+	}
+	return op && (totpEnabled || s.erupeConfig.API.RequireOpTOTP)
+}
+
+func (s *Server) validateLogin(user string, pass string, ip string) (uint32, RespID) {
+	if s.loginLocked(user, ip) {
+		s.logger.Info("Login locked", zap.String("User", user), zap.String("IP", ip))
+		s.recordLoginAttempt(0, user, ip, false, true)
+		return 0, SIGN_ELOCK
+	}
 	var uid uint32
 	var passDB string
 	err := s.db.QueryRow(`SELECT id, password FROM users WHERE username = $1`, user).Scan(&uid, &passDB)
@@ -234,11 +285,13 @@ func (s *Server) validateLogin(user string, pass string) (uint32, RespID) {
 			if s.erupeConfig.AutoCreateAccount {
 				uid, err = s.registerDBAccount(user, pass)
 				if err == nil {
+					s.recordLoginAttempt(uid, user, ip, true, false)
 					return uid, SIGN_SUCCESS
 				} else {
 					return 0, SIGN_EABORT
 				}
 			}
+			s.recordLoginAttempt(0, user, ip, false, false)
 			return 0, SIGN_EAUTH
 		}
 		return 0, SIGN_EABORT
@@ -253,8 +306,14 @@ func (s *Server) validateLogin(user string, pass string) (uint32, RespID) {
 			if err == nil && bans > 0 {
 				return uid, SIGN_ESUSPEND
 			}
+			if s.needsSecondFactor(uid) {
+				s.logger.Info("Operator login without second factor refused", zap.String("User", user), zap.String("IP", ip))
+				return 0, SIGN_ERIGHT
+			}
+			s.recordLoginAttempt(uid, user, ip, true, false)
 			return uid, SIGN_SUCCESS
 		}
+		s.recordLoginAttempt(uid, user, ip, false, false)
 		return 0, SIGN_EPASS
 	}
 }
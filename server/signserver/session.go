@@ -55,6 +55,13 @@ func (s *Session) work() {
 func (s *Session) handlePacket(pkt []byte) error {
 	bf := byteframe.NewByteFrameFromBytes(pkt)
 	reqType := string(bf.ReadNullTerminatedBytes())
+	s.server.Lock()
+	draining := s.server.isDraining
+	s.server.Unlock()
+	if draining && reqType[:len(reqType)-3] != "DELETE:" {
+		s.sendCode(SIGN_EMAINTE)
+		return nil
+	}
 	switch reqType[:len(reqType)-3] {
 	case "DLTSKEYSIGN:", "DSGN:", "SIGN:":
 		s.handleDSGN(bf)
@@ -97,7 +104,7 @@ func (s *Session) authenticate(username string, password string) {
 		newCharaReq = true
 	}
 	bf := byteframe.NewByteFrame()
-	uid, resp := s.server.validateLogin(username, password)
+	uid, resp := s.server.validateLogin(username, password, s.remoteIP())
 	switch resp {
 	case SIGN_SUCCESS:
 		if newCharaReq {
@@ -127,6 +134,10 @@ func (s *Session) handleWIIUSGN(bf *byteframe.ByteFrame) {
 		s.sendCode(SIGN_EABORT)
 		return
 	}
+	if s.server.needsSecondFactor(uid) {
+		s.sendCode(SIGN_ERIGHT)
+		return
+	}
 	s.cryptConn.SendPacket(s.makeSignResponse(uid))
 }
 
@@ -153,6 +164,10 @@ func (s *Session) handlePSSGN(bf *byteframe.ByteFrame) {
 		s.sendCode(SIGN_EABORT)
 		return
 	}
+	if s.server.needsSecondFactor(uid) {
+		s.sendCode(SIGN_ERIGHT)
+		return
+	}
 	s.cryptConn.SendPacket(s.makeSignResponse(uid))
 }
 
@@ -160,10 +175,10 @@ func (s *Session) handlePSNLink(bf *byteframe.ByteFrame) {
 	_ = bf.ReadNullTerminatedBytes() // Client ID
 	credentials := strings.Split(stringsupport.SJISToUTF8(bf.ReadNullTerminatedBytes()), "\n")
 	token := string(bf.ReadNullTerminatedBytes())
-	uid, resp := s.server.validateLogin(credentials[0], credentials[1])
+	uid, resp := s.server.validateLogin(credentials[0], credentials[1], s.remoteIP())
 	if resp == SIGN_SUCCESS && uid > 0 {
 		var psn string
-		err := s.server.db.QueryRow(`SELECT psn_id FROM sign_sessions WHERE token = $1`, token).Scan(&psn)
+		err := s.server.db.QueryRow(`SELECT psn_id FROM sign_sessions WHERE token = $1 AND (expires IS NULL OR expires > now())`, token).Scan(&psn)
 		if err != nil {
 			s.sendCode(SIGN_ECOGLINK)
 			return
@@ -206,6 +221,14 @@ func (s *Session) handleDSGN(bf *byteframe.ByteFrame) {
 	s.authenticate(user, pass)
 }
 
+func (s *Session) remoteIP() string {
+	host, _, err := net.SplitHostPort(s.rawConn.RemoteAddr().String())
+	if err != nil {
+		return s.rawConn.RemoteAddr().String()
+	}
+	return host
+}
+
 func (s *Session) sendCode(id RespID) {
 	s.cryptConn.SendPacket([]byte{byte(id)})
 }
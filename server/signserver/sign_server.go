@@ -28,6 +28,7 @@ type Server struct {
 	db             *sqlx.DB
 	listener       net.Listener
 	isShuttingDown bool
+	isDraining     bool
 }
 
 // NewServer creates a new Server type.
@@ -49,10 +50,18 @@ func (s *Server) Start() error {
 	s.listener = l
 
 	go s.acceptClients()
+	go s.cleanupTokens()
 
 	return nil
 }
 
+// Drain stops the server from accepting new logins ahead of a shutdown.
+func (s *Server) Drain() {
+	s.Lock()
+	s.isDraining = true
+	s.Unlock()
+}
+
 // Shutdown exits the server gracefully.
 func (s *Server) Shutdown() {
 	s.logger.Debug("Shutting down...")